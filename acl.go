@@ -0,0 +1,195 @@
+package go_conn_manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// ACLOrder 访问控制列表的匹配顺序
+type ACLOrder int8
+
+const (
+	ACL_Order_Allow_First ACLOrder = iota // 先匹配Allow，匹配时接受，否则再匹配Deny，匹配时拒绝
+	ACL_Order_Deny_First                  // 先匹配Deny，匹配时拒绝，否则再匹配Allow，匹配时接受
+)
+
+// ErrAccessDenied 对方的地址被访问控制列表拒绝
+var ErrAccessDenied = errors.New("对方的地址被访问控制拒绝")
+
+// AccessList 按对方IP接受或拒绝新连接的访问控制列表，在接受连接后、创建Conn前检查。
+// Allow与Deny都不匹配时，Allow为空则接受，否则拒绝（只接受Allow中的网段）。
+// IPv4映射的IPv6地址按IPv4地址匹配
+type AccessList struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+	Order ACLOrder
+}
+
+// Permit 返回是否接受来自ip的连接
+func (l *AccessList) Permit(ip netip.Addr) bool {
+	ip = ip.Unmap().WithZone("")
+	if l.Order == ACL_Order_Deny_First {
+		if matchPrefixes(l.Deny, ip) {
+			return false
+		}
+		if matchPrefixes(l.Allow, ip) {
+			return true
+		}
+	} else {
+		if matchPrefixes(l.Allow, ip) {
+			return true
+		}
+		if matchPrefixes(l.Deny, ip) {
+			return false
+		}
+	}
+	return len(l.Allow) == 0
+}
+
+func matchPrefixes(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAccessList 解析访问控制列表，每行一条规则，#之后为注释：
+//
+//	order deny,allow   # 或 order allow,deny（默认）
+//	allow 10.0.0.0/8
+//	allow fd00::/8
+//	deny 192.168.1.100 # 单个IP
+func ParseAccessList(r io.Reader) (*AccessList, error) {
+	l := &AccessList{}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text, _, _ := strings.Cut(s.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("第%d行: 格式错误: %q", line, s.Text())
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "order":
+			switch strings.ToLower(fields[1]) {
+			case "allow,deny":
+				l.Order = ACL_Order_Allow_First
+			case "deny,allow":
+				l.Order = ACL_Order_Deny_First
+			default:
+				return nil, fmt.Errorf("第%d行: 未知的顺序: %q", line, fields[1])
+			}
+		case "allow", "deny":
+			p, err := parsePrefix(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("第%d行: %w", line, err)
+			}
+			if strings.EqualFold(fields[0], "allow") {
+				l.Allow = append(l.Allow, p)
+			} else {
+				l.Deny = append(l.Deny, p)
+			}
+		default:
+			return nil, fmt.Errorf("第%d行: 未知的规则: %q", line, fields[0])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// parsePrefix 解析CIDR，单个IP按/32或/128处理，IPv4映射的IPv6网段转换为IPv4网段
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return unmapPrefix(p), nil
+}
+
+// LoadAccessList 从文件中读取访问控制列表，格式见ParseAccessList
+func LoadAccessList(path string) (*AccessList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseAccessList(f)
+}
+
+// SetAccessList 设置新连接的访问控制列表，为nil时不检查。可以在服务运行时调用，
+// 新的列表原子地替换原来的列表，对之后接受的连接生效，已建立的连接不受影响
+func (en *engine) SetAccessList(l *AccessList) {
+	if l == nil {
+		en.acl.Store(nil)
+		return
+	}
+
+	// 复制一份，之后修改l不影响正在使用的列表
+	acl := &AccessList{Order: l.Order}
+	for _, p := range l.Allow {
+		acl.Allow = append(acl.Allow, unmapPrefix(p))
+	}
+	for _, p := range l.Deny {
+		acl.Deny = append(acl.Deny, unmapPrefix(p))
+	}
+	en.acl.Store(acl)
+}
+
+// unmapPrefix 把IPv4映射的IPv6网段（如::ffff:10.0.0.0/104）转换为IPv4网段
+func unmapPrefix(p netip.Prefix) netip.Prefix {
+	p = p.Masked()
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p
+}
+
+// ReloadAccessList 从文件中重新读取访问控制列表并替换原来的列表，
+// 读取或解析失败时返回错误，继续使用原来的列表
+func (en *engine) ReloadAccessList(path string) error {
+	l, err := LoadAccessList(path)
+	if err != nil {
+		return err
+	}
+	en.SetAccessList(l)
+	return nil
+}
+
+// AccessList 返回正在使用的访问控制列表，未设置时返回nil，不能修改返回的列表
+func (en *engine) AccessList() *AccessList {
+	return en.acl.Load()
+}
+
+// permit 按访问控制列表检查新接受的套接字，拒绝时关闭nfd并返回false
+func (en *engine) permit(nfd int, sa syscall.Sockaddr) bool {
+	acl := en.acl.Load()
+	if acl == nil || acl.Permit(sockaddrToAddrPort(sa).Addr()) {
+		return true
+	}
+
+	en.admission.denied.Add(1)
+	en.rejected(nfd, sa, ErrAccessDenied)
+	return false
+}
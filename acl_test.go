@@ -0,0 +1,107 @@
+package go_conn_manager
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func prefixes(s ...string) []netip.Prefix {
+	ps := make([]netip.Prefix, len(s))
+	for i, p := range s {
+		ps[i] = netip.MustParsePrefix(p)
+	}
+	return ps
+}
+
+func TestParseAccessList(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want *AccessList // 为nil时应返回错误
+	}{
+		{"空", "", &AccessList{}},
+		{"注释与空行", "# 注释\n\n  \t\nallow 10.0.0.0/8 # 内网\n", &AccessList{Allow: prefixes("10.0.0.0/8")}},
+		{"顺序", "order deny,allow\nallow 10.0.0.0/8\ndeny 10.1.0.0/16", &AccessList{
+			Allow: prefixes("10.0.0.0/8"), Deny: prefixes("10.1.0.0/16"), Order: ACL_Order_Deny_First}},
+		{"默认顺序", "order allow,deny\ndeny ::1", &AccessList{Deny: prefixes("::1/128")}},
+		{"大小写", "ORDER Deny,Allow\nALLOW fd00::/8", &AccessList{Allow: prefixes("fd00::/8"), Order: ACL_Order_Deny_First}},
+		{"单个IP", "allow 192.168.1.1\nallow 2001:db8::1", &AccessList{Allow: prefixes("192.168.1.1/32", "2001:db8::1/128")}},
+		{"IPv4映射的IP", "deny ::ffff:192.168.1.1", &AccessList{Deny: prefixes("192.168.1.1/32")}},
+		{"IPv4映射的网段", "allow ::ffff:10.0.0.0/104", &AccessList{Allow: prefixes("10.0.0.0/8")}},
+		{"未知的规则", "permit 10.0.0.0/8", nil},
+		{"未知的顺序", "order first", nil},
+		{"缺少地址", "allow", nil},
+		{"多余的字段", "allow 10.0.0.0/8 10.1.0.0/16", nil},
+		{"地址错误", "allow 10.0.0.256", nil},
+		{"网段错误", "deny 10.0.0.0/33", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := ParseAccessList(strings.NewReader(tt.text))
+			if tt.want == nil {
+				if err == nil || !strings.HasPrefix(err.Error(), "第") {
+					t.Fatalf("返回%v, %v，应返回带行号的错误", l, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(l, tt.want) {
+				t.Fatalf("返回%v, %v，应为%v", l, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessListPermit(t *testing.T) {
+	allowFirst := &AccessList{Allow: prefixes("10.0.0.0/8"), Deny: prefixes("10.1.0.0/16", "0.0.0.0/0")}
+	denyFirst := &AccessList{Allow: prefixes("10.1.2.0/24", "::/0"), Deny: prefixes("10.1.0.0/16"), Order: ACL_Order_Deny_First}
+	denyOnly := &AccessList{Deny: prefixes("192.168.0.0/16", "fe80::/10")}
+	tests := []struct {
+		name string
+		l    *AccessList
+		ip   string
+		want bool
+	}{
+		{"先Allow：Allow匹配", allowFirst, "10.1.2.3", true},
+		{"先Allow：只有Deny匹配", allowFirst, "192.168.1.1", false},
+		{"先Allow：都不匹配", allowFirst, "2001:db8::1", false},
+		{"先Deny：Deny匹配", denyFirst, "10.1.2.3", false},
+		{"先Deny：只有Allow匹配", denyFirst, "2001:db8::1", true},
+		{"先Deny：都不匹配", denyFirst, "172.16.0.1", false},
+		{"只有Deny：匹配", denyOnly, "192.168.1.1", false},
+		{"只有Deny：不匹配", denyOnly, "10.0.0.1", true},
+		{"只有Deny：带zone", denyOnly, "fe80::1%eth0", false},
+		{"IPv4映射的地址按IPv4匹配", allowFirst, "::ffff:10.2.3.4", true},
+		{"IPv4映射的地址按IPv4拒绝", denyOnly, "::ffff:192.168.1.1", false},
+		{"先Deny：IPv4映射的地址", denyFirst, "::ffff:10.1.2.3", false},
+		{"空列表", &AccessList{}, "1.2.3.4", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.l.Permit(netip.MustParseAddr(tt.ip)); got != tt.want {
+				t.Fatalf("Permit(%s)返回%v", tt.ip, got)
+			}
+		})
+	}
+}
+
+// SetAccessList复制列表，并把IPv4映射的网段转换为IPv4网段
+func TestSetAccessList(t *testing.T) {
+	var en engine
+	l := &AccessList{Allow: prefixes("::ffff:10.0.0.0/104")}
+	en.SetAccessList(l)
+	l.Allow[0] = netip.MustParsePrefix("0.0.0.0/0")
+
+	acl := en.AccessList()
+	if want := prefixes("10.0.0.0/8"); !reflect.DeepEqual(acl.Allow, want) {
+		t.Fatalf("Allow为%v，应为%v", acl.Allow, want)
+	}
+	if !acl.Permit(netip.MustParseAddr("10.1.1.1")) || acl.Permit(netip.MustParseAddr("11.1.1.1")) {
+		t.Fatal("复制后的列表不正确")
+	}
+	en.SetAccessList(nil)
+	if en.AccessList() != nil {
+		t.Fatal("SetAccessList(nil)后仍在检查")
+	}
+}
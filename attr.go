@@ -0,0 +1,63 @@
+package go_conn_manager
+
+// AttrKey 连接属性的key，T为属性值的类型。每个NewAttrKey创建的key互不冲突，即使名称相同，
+// 不同的中间件（认证、会话、统计等）各自创建key，就不会覆盖对方的数据。
+// 所有方法都可以并发调用
+type AttrKey[T any] struct {
+	name string
+}
+
+// NewAttrKey 创建属性key，name只用于调试输出
+func NewAttrKey[T any](name string) *AttrKey[T] {
+	return &AttrKey[T]{name: name}
+}
+
+func (k *AttrKey[T]) String() string {
+	return k.name
+}
+
+// Get 获取连接c上该key的值，未设置时返回零值与false
+func (k *AttrKey[T]) Get(c *Conn) (T, bool) {
+	c.attrMu.Lock()
+	v, ok := c.attrs[k]
+	c.attrMu.Unlock()
+
+	// T为接口类型时保存的值可能为nil，类型断言需要带ok，否则panic
+	t, _ := v.(T)
+	return t, ok
+}
+
+// Set 设置连接c上该key的值
+func (k *AttrKey[T]) Set(c *Conn, v T) {
+	c.attrMu.Lock()
+	defer c.attrMu.Unlock()
+
+	if c.attrs == nil {
+		c.attrs = make(map[interface{}]interface{})
+	}
+	c.attrs[k] = v
+}
+
+// LoadOrStore 已设置时返回已有的值与true，否则设置为v并返回v与false
+func (k *AttrKey[T]) LoadOrStore(c *Conn, v T) (T, bool) {
+	c.attrMu.Lock()
+	defer c.attrMu.Unlock()
+
+	if old, ok := c.attrs[k]; ok {
+		t, _ := old.(T)
+		return t, true
+	}
+	if c.attrs == nil {
+		c.attrs = make(map[interface{}]interface{})
+	}
+	c.attrs[k] = v
+	return v, false
+}
+
+// Delete 删除连接c上该key的值
+func (k *AttrKey[T]) Delete(c *Conn) {
+	c.attrMu.Lock()
+	defer c.attrMu.Unlock()
+
+	delete(c.attrs, k)
+}
@@ -0,0 +1,52 @@
+package go_conn_manager
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestAttrKey(t *testing.T) {
+	c := &Conn{}
+	n := NewAttrKey[int]("n")
+	if v, ok := n.Get(c); ok || v != 0 {
+		t.Fatalf("未设置时返回%v, %v", v, ok)
+	}
+	n.Set(c, 1)
+	if v, ok := n.Get(c); !ok || v != 1 {
+		t.Fatalf("Get返回%v, %v", v, ok)
+	}
+	if v, loaded := n.LoadOrStore(c, 2); !loaded || v != 1 {
+		t.Fatalf("LoadOrStore返回%v, %v", v, loaded)
+	}
+	// 名称相同的key互不冲突
+	if _, ok := NewAttrKey[int]("n").Get(c); ok {
+		t.Fatal("不同的key读到了同一个值")
+	}
+	n.Delete(c)
+	if v, loaded := n.LoadOrStore(c, 3); loaded || v != 3 {
+		t.Fatalf("删除后LoadOrStore返回%v, %v", v, loaded)
+	}
+}
+
+// T为接口类型时可以保存nil
+func TestAttrKeyInterfaceNil(t *testing.T) {
+	c := &Conn{}
+	e := NewAttrKey[error]("e")
+	e.Set(c, nil)
+	if v, ok := e.Get(c); !ok || v != nil {
+		t.Fatalf("Get返回%v, %v", v, ok)
+	}
+	if v, loaded := e.LoadOrStore(c, errors.New("x")); !loaded || v != nil {
+		t.Fatalf("LoadOrStore返回%v, %v", v, loaded)
+	}
+
+	var s fmt.Stringer
+	k := NewAttrKey[fmt.Stringer]("s")
+	if v, loaded := k.LoadOrStore(c, s); loaded || v != nil {
+		t.Fatalf("LoadOrStore返回%v, %v", v, loaded)
+	}
+	if v, ok := k.Get(c); !ok || v != nil {
+		t.Fatalf("Get返回%v, %v", v, ok)
+	}
+}
@@ -0,0 +1,214 @@
+package go_conn_manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// LengthFieldVarint 作为LengthFieldConfig.LengthFieldLength时表示长度字段使用uvarint编码
+const LengthFieldVarint = -1
+
+// ErrFrameTooLarge 数据包超出最大长度限制，可用errors.Is判断
+var ErrFrameTooLarge = errors.New("数据包超出最大长度限制")
+
+// FrameTooLargeError Codec遇到超出最大长度限制的数据包时返回的错误
+type FrameTooLargeError struct {
+	Size  int // 数据包总长度（包括头部），为0时表示长度未知，无法跳过该数据包
+	Limit int // 最大长度限制
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("%s: %d > %d", ErrFrameTooLarge.Error(), e.Size, e.Limit)
+}
+
+func (e *FrameTooLargeError) Is(target error) bool {
+	return target == ErrFrameTooLarge
+}
+
+// ErrMalformedFrame 数据包格式错误，可用errors.Is判断
+var ErrMalformedFrame = errors.New("数据包格式错误")
+
+// MalformedFrameError Codec遇到格式错误的数据包（如长度字段不合法）时返回的错误，
+// 多路复用实例以该错误为原因关闭连接（调用OnClose）
+type MalformedFrameError struct {
+	Err error // 具体的错误
+}
+
+func (e *MalformedFrameError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrMalformedFrame.Error(), e.Err)
+}
+
+func (e *MalformedFrameError) Is(target error) bool {
+	return target == ErrMalformedFrame
+}
+
+func (e *MalformedFrameError) Unwrap() error {
+	return e.Err
+}
+
+// Codec 编解码器，负责封包与拆包。每个server（多路复用实例）可以配置各自的Codec，互不影响
+type Codec interface {
+	// Decode 从buf（连接上按顺序到达的字节流）中拆出一个完整的数据包，
+	// 返回数据包内容以及该包在buf中占用的字节数；若buf中的数据还不足一个完整的数据包，
+	// 则返回 nil, 0, nil，等待更多数据到达。返回的数据可以引用buf。
+	// 数据包超出最大长度限制时应尽早（读到长度字段即）返回*FrameTooLargeError，以便跳过或关闭连接；
+	// 数据包格式错误时返回*MalformedFrameError，返回其他错误时按*MalformedFrameError处理
+	Decode(buf []byte) (data []byte, n int, err error)
+	// Encode 封包，返回可以直接发送给对方的数据
+	Encode(data []byte) ([]byte, error)
+}
+
+// LengthFieldConfig LengthFieldCodec的配置，一个数据包的结构为：
+// [LengthFieldOffset字节][长度字段][身体]，
+// 数据包总长度 = LengthFieldOffset + 长度字段字节数 + 长度字段的值 + LengthAdjustment
+type LengthFieldConfig struct {
+	LengthFieldOffset   int              // 长度字段在数据包中的偏移量
+	LengthFieldLength   int              // 长度字段的字节数，可选1、2、4、8或LengthFieldVarint
+	ByteOrder           binary.ByteOrder // 长度字段的字节序，为nil时使用大端序，uvarint编码时忽略
+	LengthAdjustment    int              // 长度字段值的修正值，如长度字段的值包括了头部长度，则为头部长度的负数
+	InitialBytesToStrip int              // 拆包后从数据包开头去掉的字节数，一般为头部长度
+	ReadMaxLen          int              // 接收数据包的最大长度（包括头部），为0时不限制
+	WriteMaxLen         int              // 发送数据包的最大长度（包括头部），为0时不限制
+}
+
+// LengthFieldCodec 默认的编解码器：头部记录数据长度，身体保存数据。
+// Encode时在数据前加上LengthFieldOffset个0字节以及长度字段，不受InitialBytesToStrip影响
+type LengthFieldCodec struct {
+	cfg LengthFieldConfig
+}
+
+// NewLengthFieldCodec 创建头部只有一个大端序长度字段的LengthFieldCodec实例，
+// headerLen为头部长度，只能为1、2、4、8，否则panic；
+// readMaxLen与writeMaxLen分别为接收与发送数据包的最大长度（包括头部）
+func NewLengthFieldCodec(headerLen, readMaxLen, writeMaxLen int) *LengthFieldCodec {
+	lc, err := NewLengthFieldCodecWithConfig(LengthFieldConfig{
+		LengthFieldLength:   headerLen,
+		InitialBytesToStrip: headerLen,
+		ReadMaxLen:          readMaxLen,
+		WriteMaxLen:         writeMaxLen,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return lc
+}
+
+// NewLengthFieldCodecWithConfig 根据配置创建LengthFieldCodec实例，配置不合法时返回错误
+func NewLengthFieldCodecWithConfig(cfg LengthFieldConfig) (*LengthFieldCodec, error) {
+	switch cfg.LengthFieldLength {
+	case 1, 2, 4, 8, LengthFieldVarint:
+	default:
+		return nil, errors.New("长度字段字节数只能为1、2、4、8或LengthFieldVarint")
+	}
+	if cfg.LengthFieldOffset < 0 || cfg.InitialBytesToStrip < 0 {
+		return nil, errors.New("偏移量与去掉的字节数不能为负数")
+	}
+	if cfg.ReadMaxLen < 0 || cfg.WriteMaxLen < 0 {
+		return nil, errors.New("数据包最大长度不能为负数")
+	}
+	if cfg.ByteOrder == nil {
+		cfg.ByteOrder = binary.BigEndian
+	}
+
+	return &LengthFieldCodec{cfg: cfg}, nil
+}
+
+func (lc *LengthFieldCodec) Decode(buf []byte) ([]byte, int, error) {
+	offset := lc.cfg.LengthFieldOffset
+	value, fieldLen, err := lc.readLength(buf)
+	if err != nil || fieldLen == 0 {
+		return nil, 0, err
+	}
+
+	// 先在uint64范围内判断，避免长度字段的值过大时溢出
+	if value > math.MaxInt32 {
+		return nil, 0, &FrameTooLargeError{Limit: lc.cfg.ReadMaxLen}
+	}
+	frameLen := offset + fieldLen + int(value) + lc.cfg.LengthAdjustment
+	if frameLen < offset+fieldLen {
+		return nil, 0, &MalformedFrameError{Err: errors.New("长度字段的值小于头部长度")}
+	}
+	if lc.cfg.ReadMaxLen > 0 && frameLen > lc.cfg.ReadMaxLen {
+		return nil, 0, &FrameTooLargeError{Size: frameLen, Limit: lc.cfg.ReadMaxLen}
+	}
+	if frameLen < lc.cfg.InitialBytesToStrip {
+		return nil, 0, &MalformedFrameError{Err: errors.New("去掉的字节数大于数据包长度")}
+	}
+	if frameLen > len(buf) {
+		return nil, 0, nil
+	}
+
+	return buf[lc.cfg.InitialBytesToStrip:frameLen], frameLen, nil
+}
+
+// readLength 读取长度字段，返回长度字段的值与其占用的字节数；数据不足时占用的字节数为0
+func (lc *LengthFieldCodec) readLength(buf []byte) (uint64, int, error) {
+	offset := lc.cfg.LengthFieldOffset
+	if lc.cfg.LengthFieldLength == LengthFieldVarint {
+		if len(buf) <= offset {
+			return 0, 0, nil
+		}
+		value, n := binary.Uvarint(buf[offset:])
+		if n < 0 {
+			return 0, 0, &MalformedFrameError{Err: errors.New("长度字段溢出")}
+		}
+		return value, n, nil
+	}
+
+	fieldLen := lc.cfg.LengthFieldLength
+	if len(buf) < offset+fieldLen {
+		return 0, 0, nil
+	}
+	field := buf[offset : offset+fieldLen]
+	switch fieldLen {
+	case 1:
+		return uint64(field[0]), fieldLen, nil
+	case 2:
+		return uint64(lc.cfg.ByteOrder.Uint16(field)), fieldLen, nil
+	case 4:
+		return uint64(lc.cfg.ByteOrder.Uint32(field)), fieldLen, nil
+	default:
+		return lc.cfg.ByteOrder.Uint64(field), fieldLen, nil
+	}
+}
+
+func (lc *LengthFieldCodec) Encode(data []byte) ([]byte, error) {
+	value := len(data) - lc.cfg.LengthAdjustment
+	if value < 0 {
+		return nil, errors.New("长度字段的值不能为负数")
+	}
+
+	fieldLen := lc.cfg.LengthFieldLength
+	if fieldLen == LengthFieldVarint {
+		var tmp [binary.MaxVarintLen64]byte
+		fieldLen = binary.PutUvarint(tmp[:], uint64(value))
+	} else if fieldLen < 8 && uint64(value) >= 1<<(8*uint(fieldLen)) {
+		return nil, errors.New("数据长度超出长度字段的表示范围")
+	}
+
+	headerLen := lc.cfg.LengthFieldOffset + fieldLen
+	if lc.cfg.WriteMaxLen > 0 && headerLen+len(data) > lc.cfg.WriteMaxLen {
+		return nil, &FrameTooLargeError{Size: headerLen + len(data), Limit: lc.cfg.WriteMaxLen}
+	}
+
+	retData := make([]byte, headerLen+len(data))
+	// 写入数据长度
+	field := retData[lc.cfg.LengthFieldOffset:headerLen]
+	switch lc.cfg.LengthFieldLength {
+	case LengthFieldVarint:
+		binary.PutUvarint(field, uint64(value))
+	case 1:
+		field[0] = byte(value)
+	case 2:
+		lc.cfg.ByteOrder.PutUint16(field, uint16(value))
+	case 4:
+		lc.cfg.ByteOrder.PutUint32(field, uint32(value))
+	case 8:
+		lc.cfg.ByteOrder.PutUint64(field, uint64(value))
+	}
+	copy(retData[headerLen:], data)
+
+	return retData, nil
+}
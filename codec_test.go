@@ -0,0 +1,277 @@
+package go_conn_manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// 格式错误的数据包以*MalformedFrameError为原因关闭连接，调用OnClose而不是OnError
+func TestMalformedFrameClose(t *testing.T) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			m := b.new()
+			// 长度字段的值包括头部
+			codec, err := NewLengthFieldCodecWithConfig(LengthFieldConfig{
+				LengthFieldLength:   2,
+				LengthAdjustment:    -2,
+				InitialBytesToStrip: 2,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.SetCodec(codec)
+			closed := make(chan error, 1)
+			h := &testHandler{
+				onClose: func(c *Conn) { closed <- context.Cause(c.Context()) },
+				onError: func(c *Conn) { closed <- errors.New("调用了OnError") },
+			}
+			addr := startTestServer(t, m, h)
+
+			c := dialTest(t, addr)
+			c.Write([]byte{0, 1})
+			select {
+			case reason := <-closed:
+				var me *MalformedFrameError
+				if !errors.As(reason, &me) || !errors.Is(reason, ErrMalformedFrame) {
+					t.Fatalf("关闭原因为%v", reason)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("连接未关闭")
+			}
+		})
+	}
+}
+
+func TestLengthFieldCodec(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 300)
+	tests := []struct {
+		name  string
+		cfg   LengthFieldConfig
+		msg   []byte
+		frame []byte // Encode(msg)的结果，Decode返回frame[InitialBytesToStrip:]
+	}{
+		{"1字节", LengthFieldConfig{LengthFieldLength: 1, InitialBytesToStrip: 1}, []byte("abc"), []byte{3, 'a', 'b', 'c'}},
+		{"2字节", LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: 2}, []byte("abc"), []byte{0, 3, 'a', 'b', 'c'}},
+		{"4字节", LengthFieldConfig{LengthFieldLength: 4, InitialBytesToStrip: 4}, []byte("abc"), []byte{0, 0, 0, 3, 'a', 'b', 'c'}},
+		{"8字节", LengthFieldConfig{LengthFieldLength: 8, InitialBytesToStrip: 8}, []byte("abc"), []byte{0, 0, 0, 0, 0, 0, 0, 3, 'a', 'b', 'c'}},
+		{"小端序", LengthFieldConfig{LengthFieldLength: 4, ByteOrder: binary.LittleEndian, InitialBytesToStrip: 4}, []byte("abc"), []byte{3, 0, 0, 0, 'a', 'b', 'c'}},
+		{"空数据", LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: 2}, []byte{}, []byte{0, 0}},
+		{"varint单字节", LengthFieldConfig{LengthFieldLength: LengthFieldVarint, InitialBytesToStrip: 1}, []byte("abc"), []byte{3, 'a', 'b', 'c'}},
+		{"varint多字节", LengthFieldConfig{LengthFieldLength: LengthFieldVarint, InitialBytesToStrip: 2}, long, append([]byte{0xac, 0x02}, long...)},
+		{"不去掉头部", LengthFieldConfig{LengthFieldLength: 2}, []byte("abc"), []byte{0, 3, 'a', 'b', 'c'}},
+		{"偏移量", LengthFieldConfig{LengthFieldOffset: 2, LengthFieldLength: 2, InitialBytesToStrip: 4}, []byte("abc"), []byte{0, 0, 0, 3, 'a', 'b', 'c'}},
+		{"偏移量只去掉前缀", LengthFieldConfig{LengthFieldOffset: 1, LengthFieldLength: 1, InitialBytesToStrip: 1}, []byte("abc"), []byte{0, 3, 'a', 'b', 'c'}},
+		{"长度包括头部", LengthFieldConfig{LengthFieldLength: 2, LengthAdjustment: -2, InitialBytesToStrip: 2}, []byte("abc"), []byte{0, 5, 'a', 'b', 'c'}},
+		{"长度不包括尾部", LengthFieldConfig{LengthFieldLength: 1, LengthAdjustment: 2, InitialBytesToStrip: 1}, []byte("abc"), []byte{1, 'a', 'b', 'c'}},
+		{"最大长度", LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: 2, ReadMaxLen: 5, WriteMaxLen: 5}, []byte("abc"), []byte{0, 3, 'a', 'b', 'c'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc, err := NewLengthFieldCodecWithConfig(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			frame, err := lc.Encode(tt.msg)
+			if err != nil || !bytes.Equal(frame, tt.frame) {
+				t.Fatalf("Encode返回%v, %v，应为%v", frame, err, tt.frame)
+			}
+
+			// 后面还有下一个数据包的数据
+			data, n, err := lc.Decode(append(append([]byte{}, tt.frame...), 0xff, 0xff))
+			if want := tt.frame[tt.cfg.InitialBytesToStrip:]; err != nil || n != len(tt.frame) || !bytes.Equal(data, want) {
+				t.Fatalf("Decode返回%v, %d, %v，应为%v, %d", data, n, err, want, len(tt.frame))
+			}
+
+			// 不完整的数据包等待更多数据
+			for i := 0; i < len(tt.frame); i++ {
+				if data, n, err := lc.Decode(tt.frame[:i]); data != nil || n != 0 || err != nil {
+					t.Fatalf("前%d字节Decode返回%v, %d, %v", i, data, n, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLengthFieldCodecDecodeError(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  LengthFieldConfig
+		buf  []byte
+		want error // *FrameTooLargeError时比较Size与Limit
+	}{
+		{"长度小于头部", LengthFieldConfig{LengthFieldLength: 2, LengthAdjustment: -2}, []byte{0, 1}, ErrMalformedFrame},
+		{"去掉的字节数大于数据包", LengthFieldConfig{LengthFieldLength: 1, InitialBytesToStrip: 4}, []byte{1, 'a'}, ErrMalformedFrame},
+		{"varint溢出", LengthFieldConfig{LengthFieldLength: LengthFieldVarint}, bytes.Repeat([]byte{0xff}, 11), ErrMalformedFrame},
+		{"超出最大长度", LengthFieldConfig{LengthFieldLength: 2, ReadMaxLen: 10}, []byte{0, 9}, &FrameTooLargeError{Size: 11, Limit: 10}},
+		{"包括偏移量超出最大长度", LengthFieldConfig{LengthFieldOffset: 2, LengthFieldLength: 1, ReadMaxLen: 10}, []byte{0, 0, 8}, &FrameTooLargeError{Size: 11, Limit: 10}},
+		{"长度字段的值过大", LengthFieldConfig{LengthFieldLength: 8, ReadMaxLen: 10}, []byte{0x80, 0, 0, 0, 0, 0, 0, 0}, &FrameTooLargeError{Size: 0, Limit: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc, err := NewLengthFieldCodecWithConfig(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, n, err := lc.Decode(tt.buf)
+			if n != 0 {
+				t.Fatalf("出错时返回的字节数为%d", n)
+			}
+			if fe, ok := tt.want.(*FrameTooLargeError); ok {
+				var got *FrameTooLargeError
+				if !errors.As(err, &got) || *got != *fe {
+					t.Fatalf("Decode返回%v，应为%v", err, tt.want)
+				}
+				return
+			}
+			var me *MalformedFrameError
+			if !errors.As(err, &me) || !errors.Is(err, tt.want) {
+				t.Fatalf("Decode返回%v，应为%v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLengthFieldCodecEncodeError(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  LengthFieldConfig
+		msg  []byte
+	}{
+		{"超出1字节长度字段", LengthFieldConfig{LengthFieldLength: 1}, make([]byte, 256)},
+		{"超出2字节长度字段", LengthFieldConfig{LengthFieldLength: 2}, make([]byte, 1<<16)},
+		{"长度字段为负数", LengthFieldConfig{LengthFieldLength: 2, LengthAdjustment: 4}, []byte("abc")},
+		{"超出最大长度", LengthFieldConfig{LengthFieldLength: 2, WriteMaxLen: 4}, []byte("abc")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc, err := NewLengthFieldCodecWithConfig(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b, err := lc.Encode(tt.msg); err == nil {
+				t.Fatalf("Encode返回%v，应返回错误", b)
+			}
+		})
+	}
+}
+
+func TestLengthFieldCodecConfig(t *testing.T) {
+	for _, cfg := range []LengthFieldConfig{
+		{LengthFieldLength: 3},
+		{LengthFieldLength: 0},
+		{LengthFieldLength: 2, LengthFieldOffset: -1},
+		{LengthFieldLength: 2, InitialBytesToStrip: -1},
+		{LengthFieldLength: 2, ReadMaxLen: -1},
+	} {
+		if _, err := NewLengthFieldCodecWithConfig(cfg); err == nil {
+			t.Errorf("配置%+v未返回错误", cfg)
+		}
+	}
+}
+
+type oversizeTestHandler struct {
+	testHandler
+	policy OversizePolicy
+	calls  chan *FrameTooLargeError
+}
+
+func (h *oversizeTestHandler) OnFrameTooLarge(_ *Conn, fe *FrameTooLargeError) OversizePolicy {
+	h.calls <- fe
+	return h.policy
+}
+
+// 超出最大长度的数据包按处理方式跳过或关闭连接，跳过时后面的数据包正常处理，OversizedFrames计数
+func TestOversizePolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        OversizePolicy
+		handlerPolicy OversizePolicy // FrameTooLargeHandler的返回值
+		handlerCalled bool
+		skip          bool
+	}{
+		{name: "close", policy: Oversize_Policy_Close},
+		{name: "skip", policy: Oversize_Policy_Skip, skip: true},
+		{name: "handler skip", policy: Oversize_Policy_Handler, handlerPolicy: Oversize_Policy_Skip, handlerCalled: true, skip: true},
+		{name: "handler close", policy: Oversize_Policy_Handler, handlerPolicy: Oversize_Policy_Close, handlerCalled: true},
+	}
+
+	// 超过读缓冲区的大小，需要多次读取才能丢弃
+	big := bytes.Repeat([]byte{'x'}, 4*Read_Buffer_Size)
+	for _, b := range testBackends {
+		for _, tt := range tests {
+			t.Run(b.name+"/"+tt.name, func(t *testing.T) {
+				m := b.new()
+				m.SetCodec(NewLengthFieldCodec(4, 1024, 1024))
+				engineOf(m).SetOversizePolicy(tt.policy)
+				msgs := make(chan []byte, 2)
+				closed := make(chan *Conn, 1)
+				h := &oversizeTestHandler{
+					testHandler: testHandler{
+						onMessage: func(_ *Conn, data []byte) { msgs <- append([]byte(nil), data...) },
+						onClose:   func(c *Conn) { closed <- c },
+					},
+					policy: tt.handlerPolicy,
+					calls:  make(chan *FrameTooLargeError, 1),
+				}
+				addr := startTestServer(t, m, h)
+
+				c := dialTest(t, addr)
+				frames := binary.BigEndian.AppendUint32(nil, uint32(len(big)))
+				frames = append(frames, big...)
+				frames = append(binary.BigEndian.AppendUint32(frames, 2), "ok"...)
+				// 关闭连接时对方可能还在写，只在跳过时检查写入的结果
+				if _, err := c.Write(frames); err != nil && tt.skip {
+					t.Fatal(err)
+				}
+
+				if tt.skip {
+					select {
+					case got := <-msgs:
+						if string(got) != "ok" {
+							t.Fatalf("收到%q, 期望跳过超长的数据包后收到\"ok\"", got)
+						}
+					case <-time.After(5 * time.Second):
+						t.Fatal("未收到超长数据包之后的数据包")
+					}
+				} else {
+					select {
+					case conn := <-closed:
+						var fe *FrameTooLargeError
+						if !errors.As(conn.CloseReason(), &fe) {
+							t.Fatalf("关闭原因为%v", conn.CloseReason())
+						}
+					case <-time.After(5 * time.Second):
+						t.Fatal("连接未关闭")
+					}
+					select {
+					case got := <-msgs:
+						t.Fatalf("关闭连接后收到%q", got)
+					default:
+					}
+				}
+
+				select {
+				case fe := <-h.calls:
+					if !tt.handlerCalled {
+						t.Fatal("调用了OnFrameTooLarge")
+					}
+					if fe.Size != 4+len(big) || fe.Limit != 1024 {
+						t.Fatalf("FrameTooLargeError为%+v", fe)
+					}
+				default:
+					if tt.handlerCalled {
+						t.Fatal("未调用OnFrameTooLarge")
+					}
+				}
+				if n := engineOf(m).OversizedFrames(); n != 1 {
+					t.Fatalf("OversizedFrames为%d, 期望1", n)
+				}
+			})
+		}
+	}
+}
@@ -1,36 +1,504 @@
 package go_conn_manager
 
 import (
+	"context"
+	"errors"
 	"net"
+	"net/netip"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+var (
+	ErrConnClosed  = errors.New("连接已关闭")
+	ErrWriteClosed = errors.New("连接的写端已关闭")
+)
+
+// 连接关闭的原因，可在OnClose与OnError中通过Conn.CloseReason获取，
+// 也是Conn.Context被取消的原因（context.Cause）；
+// 因数据包超出最大长度而关闭时，关闭原因为*FrameTooLargeError
+var (
+	ErrPeerClosed     = errors.New("对方关闭了连接")
+	ErrConnReset      = errors.New("连接被重置")
+	ErrIdleTimeout    = errors.New("连接长时间无通信")
+	ErrKicked         = errors.New("连接被服务端关闭")
+	ErrServerShutdown = errors.New("服务已停止")
+)
+
+// lastConnID 最后分配的连接ID，连接ID从1开始递增，在进程内唯一
+var lastConnID uint64
+
 type Conn struct {
-	mu       sync.Mutex
+	mu       sync.Mutex // 保护发送队列，保证并发发送时数据的顺序
+	id       uint64
 	fd       int
-	SockAddr syscall.Sockaddr
-	data     interface{}
-	lastTime int64 // 该套接字最后一次通信时间
+	SockAddr syscall.Sockaddr // 对方的地址
+	remote   net.Addr
+	addrPort netip.AddrPort // 对方的IP与端口，创建时计算，IPv6的zone转换为网卡名需要查询网卡
+	local    net.Addr       // 本地的地址，接受连接时通过getsockname获取
+	en       *engine
+	codec    Codec
+	stats    connStats
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+
+	timerMu       sync.Mutex // 保护timers
+	timers        connTimers
+	readDeadline  atomic.Int64 // SetReadDeadline设置的超时时间（UnixNano），为0时未设置
+	writeDeadline atomic.Int64 // SetWriteDeadline设置的超时时间（UnixNano），为0时未设置
+	idleTimeout   atomic.Int64 // SetIdleTimeout设置的空闲超时时间，为0时使用多路复用实例的interval
+	idle          wheelEntry   // 在ConnManager的时间轮中检测空闲超时
+
+	attrMu sync.Mutex // 保护data与attrs
+	data   interface{}
+	attrs  map[interface{}]interface{} // 以*AttrKey为key的属性，见AttrKey
+
+	groups   map[string]struct{} // 所在的组，由ConnManager.groupMu保护
+	identity string              // 绑定的身份，由ConnManager.identMu保护，见ConnManager.Bind
+	bound    bool                // 已绑定身份，身份可以为空字符串
+
+	inbound []byte // 读缓冲区，保存还不足一个完整数据包的数据，为空时放回池中
+	reading int32  // 待处理的读事件数，不为0时已有goroutine在读取该连接
+	discard int    // 还需要丢弃的字节数，用于跳过超出最大长度的数据包
+	peerHup int32  // 不为0时对方已关闭写端，读取时需要读到EOF
+
+	stream     atomic.Pointer[NetConn]     // 不为nil时读到的数据原样交给NetConn，不经过Codec
+	readPaused int32                       // 不为0时暂停读取（NetConn的缓冲区已满或者超出速率限制），见pauseRead
+	limiter    atomic.Pointer[rateLimiter] // 不为nil时限制读取的速率，见SetRateLimit
+
+	outbound      [][]byte // 发送队列，保存未能立即写入套接字的数据
+	pending       int      // 发送队列中的字节数
+	writable      bool     // 发送队列中的字节数超过高水位时为false，降到低水位及以下时恢复为true
+	lowWatermark  int
+	highWatermark int
+
+	notifyMu  sync.Mutex // 保证可写状态变化按顺序通知
+	notified  bool       // 最后一次通知的可写状态
+	notifying bool       // 正在调用OnWritabilityChanged，新的状态由正在通知的goroutine继续通知
+
+	shutWrite    bool  // 已调用CloseWrite或开始关闭，不再接受新的发送
+	writeShut    bool  // 已关闭套接字的写端
+	closeOnFlush error // 不为nil时发送队列清空后以该原因关闭连接
+
+	state       int32 // 连接的状态，见ConnState
+	closed      bool  // 套接字已关闭，由mu保护
+	closing     int32 // 不为0时已开始关闭连接，保证关闭流程只执行一次
+	closeReason error // 关闭原因，由mu保护
 }
 
+// UpdateLastTime 把最后一次读到数据的时间更新为当前时间，读到数据时会自动更新
 func (c *Conn) UpdateLastTime() {
-	c.lastTime = time.Now().Unix()
+	c.stats.lastRead.Store(time.Now().UnixNano())
 }
 
+// LastTime 返回最后一次读到数据的时间（Unix秒），用于检测长时间无通信的连接
 func (c *Conn) LastTime() int64 {
-	return c.lastTime
+	return c.stats.lastRead.Load() / int64(time.Second)
+}
+
+// releaseInbound 保存读缓冲区，缓冲区中没有剩余数据时放回池中
+func (c *Conn) releaseInbound(buf []byte) {
+	if len(buf) > 0 {
+		c.inbound = buf
+		return
+	}
+
+	c.inbound = nil
+	// 扩容过的缓冲区不放回池中，避免池中缓冲区越来越大
+	if cap(buf) == Read_Buffer_Size {
+		readBufferPool.Put(buf[:0])
+	}
 }
 
+// Send 使用该连接所属server的Codec封包并发送，可以并发调用，
+// 同一goroutine发送的数据按调用顺序到达，不同goroutine发送的数据包不会交错
+func (c *Conn) Send(msg []byte) error {
+	b, err := c.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.writeFrames(b, true, 1)
+}
+
+// SendFrame 不经过Codec，直接发送已封包的数据，可以并发调用
+func (c *Conn) SendFrame(raw []byte) error {
+	return c.writeFrames(raw, false, 1)
+}
+
+// SendBatch 封包并发送多个数据包，这些数据包连续发送，不会与其他goroutine发送的数据交错
+func (c *Conn) SendBatch(msgs [][]byte) error {
+	frames := make([][]byte, len(msgs))
+	total := 0
+	for i, msg := range msgs {
+		b, err := c.codec.Encode(msg)
+		if err != nil {
+			return err
+		}
+		frames[i] = b
+		total += len(b)
+	}
+
+	// 合并为一块数据，只需要一次写操作
+	buf := make([]byte, 0, total)
+	for _, b := range frames {
+		buf = append(buf, b...)
+	}
+	return c.writeFrames(buf, true, len(msgs))
+}
+
+// writeFrames 发送包含frames个数据包的数据，发送成功时计入统计
+func (c *Conn) writeFrames(b []byte, owned bool, frames int) error {
+	err := c.write(b, owned)
+	if err == nil {
+		c.stats.framesOut.Add(uint64(frames))
+	}
+	return err
+}
+
+// write 发送数据，发送队列为空时直接写入套接字，未写完的部分放入发送队列并监听可写事件，
+// 等套接字可写时再发送；发送队列不为空时直接放入队列，保证数据的顺序。
+// owned为false时b属于调用者，放入发送队列前需要拷贝
+func (c *Conn) write(b []byte, owned bool) error {
+	c.mu.Lock()
+	err := c.writeLocked(b, owned)
+	changed := c.updateWritable()
+	c.mu.Unlock()
+
+	if changed {
+		c.notifyWritability()
+	}
+	return err
+}
+
+func (c *Conn) writeLocked(b []byte, owned bool) error {
+	if err := c.stateError(); err != nil {
+		return err
+	}
+	if c.closed {
+		return ErrConnClosed
+	}
+	if c.shutWrite {
+		return ErrWriteClosed
+	}
+
+	if len(c.outbound) == 0 {
+		n, err := writeFd(c.fd, b)
+		c.stats.written(n)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		if len(b) == 0 {
+			return nil
+		}
+		err = c.en.poller.watchWrite(c, true)
+		if err != nil {
+			return err
+		}
+	}
+	if !owned {
+		b = append([]byte(nil), b...)
+	}
+	c.outbound = append(c.outbound, b)
+	c.pending += len(b)
+
+	return nil
+}
+
+// flush 套接字可写时发送队列中的数据，全部发送完后停止监听可写事件，
+// 并执行等待发送完成的CloseWrite或CloseAfterFlush
+func (c *Conn) flush() error {
+	c.mu.Lock()
+	err := c.flushLocked()
+	changed := c.updateWritable()
+	var reason error
+	if len(c.outbound) == 0 && !c.closed {
+		reason = c.closeOnFlush
+	}
+	c.mu.Unlock()
+
+	if changed {
+		c.notifyWritability()
+	}
+	if reason != nil {
+		c.en.closeConn(c, reason)
+	}
+	return err
+}
+
+func (c *Conn) flushLocked() error {
+	// 套接字关闭后fd可能已被新连接复用，不能再写
+	if c.closed || len(c.outbound) == 0 {
+		return nil
+	}
+	for len(c.outbound) > 0 {
+		b := c.outbound[0]
+		n, err := writeFd(c.fd, b)
+		c.stats.written(n)
+		c.pending -= n
+		if err != nil {
+			return err
+		}
+		if n < len(b) {
+			c.outbound[0] = b[n:]
+			return nil
+		}
+		c.outbound[0] = nil
+		c.outbound = c.outbound[1:]
+	}
+	c.outbound = nil
+
+	err := c.en.poller.watchWrite(c, false)
+	if err != nil {
+		return err
+	}
+	return c.shutdownLocked()
+}
+
+// CloseWrite 关闭连接的写端（shutdown SHUT_WR），发送队列中还有数据时等数据发送完后再关闭，
+// 对方读完数据后会读到EOF；之后发送数据返回ErrWriteClosed，读取不受影响
+func (c *Conn) CloseWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrConnClosed
+	}
+	c.shutWrite = true
+	return c.shutdownLocked()
+}
+
+// writeClosed 返回是否已调用CloseWrite或开始关闭，不再接受新的发送
+func (c *Conn) writeClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.shutWrite
+}
+
+// shutdownLocked 已调用CloseWrite且发送队列已清空时关闭套接字的写端，调用时需持有c.mu
+func (c *Conn) shutdownLocked() error {
+	if !c.shutWrite || c.writeShut || c.closeOnFlush != nil || len(c.outbound) > 0 {
+		return nil
+	}
+	c.writeShut = true
+	return syscall.Shutdown(c.fd, syscall.SHUT_WR)
+}
+
+// CloseAfterFlush 等发送队列中的数据发送完后再关闭连接，关闭原因为ErrKicked，
+// 用于发送最后一条消息后关闭连接，之后连接进入Draining状态，发送数据返回ErrConnDraining；
+// 超过timeout仍未发送完时丢弃剩余数据直接关闭。不会阻塞调用者
+func (c *Conn) CloseAfterFlush(timeout time.Duration) {
+	c.closeAfterFlush(ErrKicked, timeout)
+}
+
+// closeAfterFlush 发送队列清空后以reason关闭连接，超过timeout仍未清空时直接关闭
+func (c *Conn) closeAfterFlush(reason error, timeout time.Duration) {
+	c.mu.Lock()
+	if c.closed || c.closeOnFlush != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.shutWrite = true
+	c.closeOnFlush = reason
+	drained := len(c.outbound) == 0
+	c.mu.Unlock()
+
+	c.setState(Conn_State_Draining)
+	if drained {
+		c.en.closeConn(c, reason)
+		return
+	}
+	time.AfterFunc(timeout, func() {
+		c.en.closeConn(c, reason)
+	})
+}
+
+// updateWritable 根据发送队列中的字节数更新可写状态，返回状态是否改变，调用时需持有c.mu
+func (c *Conn) updateWritable() bool {
+	if c.writable && c.pending > c.highWatermark {
+		c.writable = false
+		return true
+	}
+	if !c.writable && c.pending <= c.lowWatermark {
+		c.writable = true
+		return true
+	}
+	return false
+}
+
+// notifyWritability 通知WritabilityHandler可写状态的变化，不在持有c.mu与c.notifyMu时调用回调，
+// 以便回调中可以继续发送数据。同一时刻只有一个goroutine调用回调，其他goroutine（包括回调中再次触发的通知）
+// 只更新状态后返回，由正在通知的goroutine在回调返回后继续通知最新的状态，且不会重复通知同一状态
+func (c *Conn) notifyWritability() {
+	if s := c.stream.Load(); s != nil {
+		notify(s.writable)
+	}
+
+	h, ok := c.en.handler.(WritabilityHandler)
+	if !ok {
+		return
+	}
+
+	c.notifyMu.Lock()
+	if c.notifying {
+		c.notifyMu.Unlock()
+		return
+	}
+	c.notifying = true
+	for {
+		writable := c.Writable()
+		if writable == c.notified {
+			break
+		}
+		c.notified = writable
+		c.notifyMu.Unlock()
+		h.OnWritabilityChanged(c, writable)
+		c.notifyMu.Lock()
+	}
+	c.notifying = false
+	c.notifyMu.Unlock()
+}
+
+// Writable 返回连接是否可写，发送队列中的字节数超过高水位后为false，
+// 此时应暂停向该连接发送数据，直到降到低水位及以下
+func (c *Conn) Writable() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.writable
+}
+
+// SetWriteWatermark 设置该连接发送队列的低水位与高水位（字节数）
+func (c *Conn) SetWriteWatermark(low, high int) {
+	c.mu.Lock()
+	c.lowWatermark = low
+	c.highWatermark = high
+	changed := c.updateWritable()
+	c.mu.Unlock()
+
+	if changed {
+		c.notifyWritability()
+	}
+}
+
+// pauseRead 暂停从套接字读取，读缓冲区中剩余的数据也不再处理，由resumeRead恢复。
+// 暂停期间套接字的接收缓冲区满后，由TCP流量控制限制对方发送
+func (c *Conn) pauseRead() {
+	// 先停止监听再设置标记，resumeRead恢复监听一定在这之后
+	c.en.poller.watchRead(c, false)
+	atomic.StoreInt32(&c.readPaused, 1)
+}
+
+// unpauseRead 取消暂停，恢复监听可读事件但不主动读取，返回false时未暂停或者已被恢复
+func (c *Conn) unpauseRead() bool {
+	if !atomic.CompareAndSwapInt32(&c.readPaused, 1, 0) {
+		return false
+	}
+	if !c.isClosing() {
+		c.en.poller.watchRead(c, true)
+	}
+	return true
+}
+
+// resumeRead 恢复被暂停的读取。边缘触发不会再产生可读事件，需要主动读取缓冲区与套接字中剩余的数据
+func (c *Conn) resumeRead() {
+	if c.unpauseRead() && !c.isClosing() {
+		c.en.handleIn(event{id: c.id, event: Event_Type_In})
+	}
+}
+
+// Close 关闭连接：停止监听该套接字、从管理器中删除、调用OnClose并关闭套接字，
+// 关闭原因为ErrKicked。可以重复调用，只有第一次生效
 func (c *Conn) Close() {
-	syscall.Close(c.fd)
+	c.en.closeConn(c, ErrKicked)
+}
+
+// Context 返回连接的Context，连接关闭时被取消，取消的原因（context.Cause）与CloseReason相同，
+// 用于在连接关闭时停止由该连接的消息发起的工作
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// CloseReason 返回连接关闭的原因，连接未关闭时返回nil
+func (c *Conn) CloseReason() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closeReason
+}
+
+// isClosed 返回套接字是否已标记为关闭
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// isClosing 返回是否已开始关闭连接
+func (c *Conn) isClosing() bool {
+	return atomic.LoadInt32(&c.closing) != 0
+}
+
+// markClosed 标记套接字已关闭并丢弃发送队列中的数据，之后不会再向该fd写数据，
+// 已经标记过时返回false
+func (c *Conn) markClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	c.outbound = nil
+	c.pending = 0
+	return true
+}
+
+// closeFd 标记并关闭套接字。有goroutine正在读取时由其在读取结束后关闭，
+// 避免fd在读取过程中被新连接复用而读到其他连接的数据
+func (c *Conn) closeFd() {
+	if !c.markClosed() {
+		return
+	}
+	// 计数不再归零，之后不会有新的goroutine读取该连接
+	if atomic.AddInt32(&c.reading, 1) == 1 {
+		syscall.Close(c.fd)
+	}
+}
+
+// ID 返回连接ID，与fd不同，连接ID不会被复用
+func (c *Conn) ID() uint64 {
+	return c.id
 }
 
 func (c *Conn) Fd() int {
 	return c.fd
 }
 
+// RemoteAddr 返回对方的地址，TCP连接为*net.TCPAddr（包括端口与IPv6的zone），Unix套接字为*net.UnixAddr
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// LocalAddr 返回本地的地址，类型与RemoteAddr相同
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddrPort 返回对方的IP与端口，用于按来源统计或限制，非IP连接返回零值
+func (c *Conn) RemoteAddrPort() netip.AddrPort {
+	return c.addrPort
+}
+
+// Addr 返回对方的IP地址
+//
+// Deprecated: 不包括端口，使用RemoteAddr或RemoteAddrPort
 func (c *Conn) Addr() net.Addr {
 	switch sa := c.SockAddr.(type) {
 	case *syscall.SockaddrInet4:
@@ -42,6 +510,9 @@ func (c *Conn) Addr() net.Addr {
 	return nil
 }
 
+// Port 返回对方的端口
+//
+// Deprecated: 使用RemoteAddr或RemoteAddrPort
 func (c *Conn) Port() int {
 	if sa, ok := c.SockAddr.(*syscall.SockaddrInet4); ok {
 		return sa.Port
@@ -52,10 +523,54 @@ func (c *Conn) Port() int {
 	return 0
 }
 
+// sockaddrToAddr 把syscall.Sockaddr转换为与传输层对应的net.Addr
+func sockaddrToAddr(sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port, Zone: zoneName(sa.ZoneId)}
+	case *syscall.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	}
+
+	return nil
+}
+
+func sockaddrToAddrPort(sa syscall.Sockaddr) netip.AddrPort {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port))
+	case *syscall.SockaddrInet6:
+		ip := netip.AddrFrom16(sa.Addr).WithZone(zoneName(sa.ZoneId))
+		return netip.AddrPortFrom(ip, uint16(sa.Port))
+	}
+
+	return netip.AddrPort{}
+}
+
+// zoneName 把IPv6的zone ID转换为网卡名，找不到网卡时使用数字
+func zoneName(id uint32) string {
+	if id == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(int(id)); err == nil {
+		return ifi.Name
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// Data 返回SetData设置的数据。多个中间件需要在连接上保存数据时使用AttrKey
 func (c *Conn) Data() interface{} {
+	c.attrMu.Lock()
+	defer c.attrMu.Unlock()
+
 	return c.data
 }
 
 func (c *Conn) SetData(d interface{}) {
+	c.attrMu.Lock()
+	defer c.attrMu.Unlock()
+
 	c.data = d
 }
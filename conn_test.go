@@ -0,0 +1,212 @@
+package go_conn_manager
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type writabilityTestHandler struct {
+	testHandler
+	onChanged func(*Conn, bool)
+}
+
+func (h *writabilityTestHandler) OnWritabilityChanged(c *Conn, writable bool) {
+	h.onChanged(c, writable)
+}
+
+// OnWritabilityChanged中发送数据再次超过高水位时，不能因为重复通知而死锁
+func TestWritabilityCallbackSend(t *testing.T) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			m := b.new()
+			en := engineOf(m)
+			opts := DefaultSocketOptions()
+			opts.SendBuffer = 4096
+			en.SetSocketOptions(opts)
+			en.SetWriteWatermark(10, 100)
+
+			big := bytes.Repeat([]byte{'x'}, 1<<20)
+			var resumed atomic.Int32
+			h := &writabilityTestHandler{
+				testHandler: testHandler{
+					onMessage: func(c *Conn, _ []byte) { c.Send(big) },
+				},
+				onChanged: func(c *Conn, writable bool) {
+					if writable && resumed.Add(1) == 1 {
+						c.Send(big)
+					}
+				},
+			}
+			addr := startTestServer(t, m, h)
+
+			c := dialTest(t, addr)
+			// 限制接收缓冲区，回调中发送的数据不能一次写完
+			c.SetReadBuffer(64 << 10)
+			writeTestFrame(t, c, []byte("go"))
+			// 等发送队列超过高水位后再读取
+			time.Sleep(100 * time.Millisecond)
+			for i := 0; i < 2; i++ {
+				if got := readTestFrame(t, c); !bytes.Equal(got, big) {
+					t.Fatalf("第%d个数据包长度为%d", i+1, len(got))
+				}
+			}
+			if resumed.Load() == 0 {
+				t.Fatal("未通知恢复可写")
+			}
+		})
+	}
+}
+
+type connEvent struct {
+	id   uint64
+	data string
+}
+
+// 关闭连接后新连接复用同一fd，回调收到的Conn应按连接ID区分，原来的连接不能收到新连接的事件
+func TestFdReuse(t *testing.T) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			m := b.new()
+			connected := make(chan *Conn, 1)
+			msgs := make(chan connEvent, 4)
+			closed := make(chan uint64, 64)
+			addr := startTestServer(t, m, &testHandler{
+				onConnect: func(c *Conn) { connected <- c },
+				onMessage: func(c *Conn, data []byte) { msgs <- connEvent{c.ID(), string(data)} },
+				onClose:   func(c *Conn) { closed <- c.ID() },
+			})
+			accepted := func() *Conn {
+				t.Helper()
+				select {
+				case c := <-connected:
+					return c
+				case <-time.After(5 * time.Second):
+					t.Fatal("未调用OnConnect")
+				}
+				return nil
+			}
+			expectMessage := func(want connEvent) {
+				t.Helper()
+				select {
+				case got := <-msgs:
+					if got != want {
+						t.Fatalf("OnMessage: %+v, 期望%+v", got, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("未调用OnMessage")
+				}
+			}
+			expectClose := func(want uint64) {
+				t.Helper()
+				select {
+				case id := <-closed:
+					if id != want {
+						t.Fatalf("OnClose的连接ID为%d, 期望%d", id, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("未调用OnClose")
+				}
+			}
+
+			// 客户端的套接字会占用最小的空闲fd，先创建好，关闭A后只有服务端接受连接时分配fd
+			tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clients := make([]int, 16)
+			for i := range clients {
+				if clients[i], err = unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0); err != nil {
+					t.Fatal(err)
+				}
+				fd := clients[i]
+				t.Cleanup(func() { unix.Close(fd) })
+			}
+
+			a := dialTest(t, addr)
+			ca := accepted()
+			writeTestFrame(t, a, []byte("a"))
+			a.Close()
+			expectMessage(connEvent{ca.ID(), "a"})
+			expectClose(ca.ID())
+			// OnClose在关闭套接字之前调用，等fd关闭后再连接才会复用
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if _, err := unix.FcntlInt(uintptr(ca.Fd()), unix.F_GETFD, 0); err == unix.EBADF {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("A的fd未关闭")
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			// 其他测试释放的更小的fd会先被使用，保持这些连接直到新连接复用A的fd
+			var bfd int
+			var cb *Conn
+			for _, fd := range clients {
+				sa := &unix.SockaddrInet4{Port: tcpAddr.Port}
+				copy(sa.Addr[:], tcpAddr.IP.To4())
+				if err := unix.Connect(fd, sa); err != nil {
+					t.Fatal(err)
+				}
+				if c := accepted(); c.Fd() == ca.Fd() {
+					bfd, cb = fd, c
+					break
+				}
+			}
+			if cb == nil {
+				t.Fatalf("新连接未复用fd %d", ca.Fd())
+			}
+			if cb.ID() == ca.ID() {
+				t.Fatal("新连接复用了连接ID")
+			}
+			cm := engineOf(m).ConnManager()
+			if cm.Get(ca.ID()) != nil || cm.Get(cb.ID()) != cb || cm.GetConnByFd(cb.Fd()) != cb {
+				t.Fatal("查找到的连接不正确")
+			}
+
+			if _, err := unix.Write(bfd, []byte{0, 0, 0, 1, 'b'}); err != nil {
+				t.Fatal(err)
+			}
+			expectMessage(connEvent{cb.ID(), "b"})
+			unix.Shutdown(bfd, unix.SHUT_WR)
+			expectClose(cb.ID())
+			if ca.CloseReason() != ErrPeerClosed {
+				t.Fatalf("原来的连接的关闭原因: %v", ca.CloseReason())
+			}
+		})
+	}
+}
+
+// RemoteAddrPort与RemoteAddr一致，IPv6的zone为网卡名
+func TestRemoteAddrPort(t *testing.T) {
+	lo, err := net.InterfaceByIndex(1)
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		sa   syscall.Sockaddr
+		want netip.AddrPort
+	}{
+		{&syscall.SockaddrInet4{Addr: [4]byte{10, 0, 0, 1}, Port: 80}, netip.MustParseAddrPort("10.0.0.1:80")},
+		{&syscall.SockaddrInet6{Addr: [16]byte{0xfe, 0x80, 15: 1}, Port: 443, ZoneId: 1}, netip.MustParseAddrPort("[fe80::1%" + lo.Name + "]:443")},
+		{&syscall.SockaddrUnix{Name: "/tmp/test.sock"}, netip.AddrPort{}},
+	}
+	var en engine
+	for _, tt := range tests {
+		c := en.newConn(-1, tt.sa, nil)
+		if got := c.RemoteAddrPort(); got != tt.want {
+			t.Errorf("%+v: RemoteAddrPort为%v, 期望%v", tt.sa, got, tt.want)
+		}
+		if a, ok := c.RemoteAddr().(*net.TCPAddr); ok && a.AddrPort() != tt.want {
+			t.Errorf("%+v: RemoteAddr为%v, 与RemoteAddrPort不一致", tt.sa, a)
+		}
+	}
+}
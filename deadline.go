@@ -0,0 +1,198 @@
+package go_conn_manager
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// 因超时关闭连接时的关闭原因
+var (
+	ErrReadTimeout  = errors.New("读超时")
+	ErrWriteTimeout = errors.New("写超时")
+)
+
+// connTimers 连接的定时器，由timerMu保护
+type connTimers struct {
+	readTimer  *time.Timer
+	writeTimer *time.Timer
+	rateTimer  *time.Timer // 超出速率限制暂停读取后恢复读取，见Conn.delayRead
+}
+
+// SetReadDeadline 设置读超时时间：到t时还没有接收到完整的数据包，则调用ReadTimeoutHandler.OnReadTimeout，
+// Handler未实现该接口时以ErrReadTimeout关闭连接。接收到完整的数据包后清除超时时间，t为零值时取消
+func (c *Conn) SetReadDeadline(t time.Time) {
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	stopTimer(&c.timers.readTimer)
+	c.readDeadline.Store(0)
+	if t.IsZero() {
+		return
+	}
+
+	ns := t.UnixNano()
+	c.readDeadline.Store(ns)
+	c.timers.readTimer = time.AfterFunc(time.Until(t), func() {
+		c.readTimeout(ns)
+	})
+}
+
+// readTimeout 读超时，ns为设置定时器时的超时时间，超时时间已被清除或重新设置时不处理
+func (c *Conn) readTimeout(ns int64) {
+	if !c.readDeadline.CompareAndSwap(ns, 0) {
+		return
+	}
+	if h, ok := c.en.handler.(ReadTimeoutHandler); ok {
+		h.OnReadTimeout(c)
+		return
+	}
+	c.en.closeConn(c, ErrReadTimeout)
+}
+
+// clearReadDeadline 接收到完整的数据包，清除读超时时间
+func (c *Conn) clearReadDeadline() {
+	if c.readDeadline.Load() == 0 {
+		return
+	}
+
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	stopTimer(&c.timers.readTimer)
+	c.readDeadline.Store(0)
+}
+
+// SetWriteDeadline 设置写超时时间：到t时发送队列中还有未发送完的数据，则丢弃这些数据并以ErrWriteTimeout关闭连接，
+// 因为未发送完的数据包已不完整，连接无法继续使用；此时发送队列已清空则不影响连接。t为零值时取消
+func (c *Conn) SetWriteDeadline(t time.Time) {
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	stopTimer(&c.timers.writeTimer)
+	c.writeDeadline.Store(0)
+	if t.IsZero() {
+		return
+	}
+
+	ns := t.UnixNano()
+	c.writeDeadline.Store(ns)
+	c.timers.writeTimer = time.AfterFunc(time.Until(t), func() {
+		c.writeTimeout(ns)
+	})
+}
+
+func (c *Conn) writeTimeout(ns int64) {
+	if !c.writeDeadline.CompareAndSwap(ns, 0) {
+		return
+	}
+
+	c.mu.Lock()
+	pending := len(c.outbound) > 0
+	c.mu.Unlock()
+	if pending {
+		c.en.closeConn(c, ErrWriteTimeout)
+	}
+}
+
+// SetIdleTimeout 设置该连接的空闲超时时间，超过d没有读到数据时以ErrIdleTimeout关闭连接，
+// 代替创建多路复用实例时指定的interval，例如未认证的连接使用较短的时间，认证后再延长。
+// d为0时恢复使用interval
+func (c *Conn) SetIdleTimeout(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	c.idleTimeout.Store(int64(d))
+	c.en.conns.scheduleIdle(c)
+}
+
+// IdleTimeout 返回SetIdleTimeout设置的空闲超时时间，未设置时返回0
+func (c *Conn) IdleTimeout() time.Duration {
+	return time.Duration(c.idleTimeout.Load())
+}
+
+// idleFor 返回距离最后一次读到数据的时间
+func (c *Conn) idleFor() time.Duration {
+	return time.Duration(time.Now().UnixNano() - c.stats.lastRead.Load())
+}
+
+// stopTimers 关闭连接时停止所有定时器
+func (c *Conn) stopTimers() {
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	stopTimer(&c.timers.readTimer)
+	stopTimer(&c.timers.writeTimer)
+	stopTimer(&c.timers.rateTimer)
+	c.readDeadline.Store(0)
+	c.writeDeadline.Store(0)
+}
+
+func stopTimer(t **time.Timer) {
+	if *t != nil {
+		(*t).Stop()
+		*t = nil
+	}
+}
+
+// deadline 可以重复设置的超时时间，超时后wait返回的channel被关闭
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+// set 设置超时时间，t为零值时取消超时，t早于当前时间时立即超时
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// 定时器已触发时等其关闭channel
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	expired := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if expired {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if expired {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !expired {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.cancel
+}
+
+func isClosedChan(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -2,81 +2,54 @@ package go_conn_manager
 
 import (
 	"golang.org/x/sys/unix"
-	"io"
-	"strconv"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
 const (
-	Listen_Queue_Size = 1024
+	Listen_Queue_Size = 1024 // 默认的listen backlog，见ListenConfig
 	Epoll_Create_Size = 1
 
 	Epoll_CTL_Listener = syscall.EPOLLIN | unix.EPOLLET | syscall.EPOLLPRI
 	Epoll_CTL_Read     = syscall.EPOLLIN | unix.EPOLLET | syscall.EPOLLPRI | syscall.EPOLLRDHUP | syscall.EPOLLHUP | syscall.EPOLLERR
+	Epoll_CTL_Write    = Epoll_CTL_Read | syscall.EPOLLOUT
 )
 
 type Epoll struct {
+	engine
 	epollFd  int
 	listenFd int
-	revents  chan event
-	conns    *ConnManager
-	handler  Handler
-	ticker   *time.Ticker
-	interval int64
+	wakeMu   sync.Mutex
+	wakeFd   int // eventfd，用于在Stop时唤醒阻塞在EpollWait上的WaitEvent，WaitEvent返回前关闭，之后为-1
 	stop     chan struct{}
 }
 
+// epollWakeID 唤醒用的eventfd在epoll_event中的ID，连接ID从1开始递增，不会与之相同
+const epollWakeID = ^uint64(0)
+
 // NewEpoll 创建Epoll实例，interval指定检测长时间未使用的连接并关闭其
 func NewEpoll(interval time.Duration) *Epoll {
-	return &Epoll{
-		revents:  make(chan event, 1024),
-		conns:    NewConnManager(interval),
-		ticker:   time.NewTicker(interval),
-		interval: int64(interval.Seconds()),
-		stop:     make(chan struct{}),
+	e := &Epoll{
+		engine: engine{
+			conns:   NewConnManager(interval),
+			revents: make(chan event, 1024),
+		},
+		wakeFd: -1,
+		stop:   make(chan struct{}),
 	}
-}
-
-func (e *Epoll) SetHandler(h Handler) {
-	e.handler = h
+	e.poller = e
+	return e
 }
 
 // 创建一个Epoll实例
 func (e *Epoll) Init(ipAddr string, port int) error {
-	// Specifying  a  protocol  of  0  causes Socket() to use an unspecified
-	// default protocol appropriate for the requested socket type.
-	listenFd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	listenFd, err := e.listen(ipAddr, port)
 	if err != nil {
 		return err
 	}
 	e.listenFd = listenFd
 
-	addr := [4]byte{}
-	if ipAddr != "" {
-		s := strings.Split(ipAddr, ".")
-		for i := range s {
-			v, err := strconv.Atoi(s[i])
-			if err != nil {
-				return err
-			}
-			addr[i] = byte(v)
-		}
-	}
-	err = syscall.Bind(e.listenFd, &syscall.SockaddrInet4{
-		Port: port,
-		Addr: addr,
-	})
-	if err != nil {
-		return err
-	}
-
-	err = syscall.Listen(e.listenFd, Listen_Queue_Size)
-	if err != nil {
-		return err
-	}
-
 	// Since Linux 2.6.8, the size argument is ignored, but must be
 	// greater than zero
 	epollFd, err := syscall.EpollCreate(Epoll_Create_Size)
@@ -85,22 +58,25 @@ func (e *Epoll) Init(ipAddr string, port int) error {
 	}
 	e.epollFd = epollFd
 
-	err = syscall.EpollCtl(epollFd, syscall.EPOLL_CTL_ADD, e.listenFd, &syscall.EpollEvent{
-		Events: Epoll_CTL_Listener,
-		Fd:     int32(listenFd),
-	})
+	// 监听套接字的ID为0，见newEpollEvent
+	err = syscall.EpollCtl(epollFd, syscall.EPOLL_CTL_ADD, e.listenFd, newEpollEvent(Epoll_CTL_Listener, 0))
 	if err != nil {
 		return err
 	}
 
-	go e.checkTimeout()
-	return nil
+	wakeFd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	e.wakeFd = wakeFd
+	return syscall.EpollCtl(epollFd, syscall.EPOLL_CTL_ADD, wakeFd, newEpollEvent(syscall.EPOLLIN, epollWakeID))
 }
 
 func (e *Epoll) WaitEvent() {
 	for {
 		select {
 		case <-e.stop:
+			e.closeWakeup()
 			close(e.revents)
 			return
 		default:
@@ -111,29 +87,37 @@ func (e *Epoll) WaitEvent() {
 			}
 
 			for i := 0; i < n; i++ {
-				if (events[i].Events & syscall.EPOLLIN) > 0 {
-					if events[i].Fd == int32(e.listenFd) {
+				id := epollEventID(&events[i])
+				// 只在Stop时唤醒，回到循环开头退出
+				if id == epollWakeID {
+					continue
+				}
+				if (events[i].Events & syscall.EPOLLOUT) > 0 {
+					e.revents <- event{
+						id:    id,
+						event: Event_Type_Out,
+					}
+				}
+				// 对方关闭连接（EPOLLRDHUP、EPOLLHUP）时也先读取，把剩余的数据包处理完后再关闭，
+				// 读到EOF时关闭连接，见handleIn
+				if (events[i].Events & (syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLHUP)) > 0 {
+					if id == 0 {
 						e.revents <- event{
-							fd:    events[i].Fd,
+							fd:    int32(e.listenFd),
 							event: Event_Type_Connect,
 						}
 					} else {
 						e.revents <- event{
-							fd:    events[i].Fd,
+							id:    id,
 							event: Event_Type_In,
+							hup:   (events[i].Events & (syscall.EPOLLRDHUP | syscall.EPOLLHUP)) > 0,
 						}
 					}
 				} else if (events[i].Events & syscall.EPOLLERR) > 0 {
 					e.revents <- event{
-						fd:    events[i].Fd,
+						id:    id,
 						event: Event_Type_Error,
 					}
-				} else if (events[i].Events&syscall.EPOLLRDHUP) > 0 || (events[i].Events&syscall.EPOLLHUP) > 0 {
-					// EPOLLHUP: FIN has been received and sent.
-					e.revents <- event{
-						fd:    events[i].Fd,
-						event: Event_Type_Close,
-					}
 				}
 
 			}
@@ -145,103 +129,107 @@ func (e *Epoll) WaitEvent() {
 func (e *Epoll) HandleEvent() error {
 	for ev := range e.revents {
 		if ev.event == Event_Type_Connect {
-			nfd, sa, err := syscall.Accept(int(ev.fd))
-			if err != nil {
-				continue
-			}
-			c := &Conn{
-				fd:       nfd,
-				SockAddr: sa,
-				lastTime: time.Now().Unix(),
-			}
-			err = e.AddRead(nfd, c)
-			if err != nil {
-				continue
-			}
-		} else if ev.event == Event_Type_Close {
-			nfd := int(ev.fd)
-			err := e.Del(nfd)
-			if err != nil {
-				continue
-			}
+			e.acceptAll(int(ev.fd), e.AddRead)
 		} else if ev.event == Event_Type_In {
-			go func(ev event) {
-				err := UnpackFromFD(e.conns.GetConn(int(ev.fd)), e.handler.OnMessage)
-				if err != nil && err == io.EOF {
-					e.revents <- event{
-						fd:    int32(ev.fd),
-						event: Event_Type_Close,
-					}
-				}
-				e.conns.GetConn(int(ev.fd)).UpdateLastTime()
-			}(ev)
+			e.handleIn(ev)
+		} else if ev.event == Event_Type_Out {
+			e.handleOut(ev)
 		} else if ev.event == Event_Type_Error {
 			// In TCP, this typically means a RST has been received or sent.
-			e.handler.OnError(e.conns.GetConn(int(ev.fd)))
-			e.conns.DelConn(int(ev.fd))
-			syscall.EpollCtl(e.epollFd, syscall.EPOLL_CTL_DEL, int(ev.fd), nil)
+			if c := e.conns.Get(ev.id); c != nil {
+				e.closeConn(c, ErrConnReset)
+			}
 		}
 	}
 	return nil
 }
 
+// Stop 停止服务，以ErrServerShutdown关闭所有连接，WaitEvent与HandleEvent随之返回
 func (e *Epoll) Stop() {
 	close(e.stop)
+	e.wakeup()
+	e.closeAll(ErrServerShutdown)
+}
+
+// wakeup 唤醒阻塞在EpollWait上的WaitEvent
+func (e *Epoll) wakeup() {
+	e.wakeMu.Lock()
+	defer e.wakeMu.Unlock()
+
+	// 已关闭时fd可能已被复用，不能再写
+	if e.wakeFd < 0 {
+		return
+	}
+	// eventfd的写入为8字节的计数，非0即可，与字节序无关
+	unix.Write(e.wakeFd, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+}
+
+// closeWakeup 关闭唤醒用的eventfd，由WaitEvent在最后一次EpollWait返回后调用
+func (e *Epoll) closeWakeup() {
+	e.wakeMu.Lock()
+	defer e.wakeMu.Unlock()
+
+	if e.wakeFd >= 0 {
+		syscall.EpollCtl(e.epollFd, syscall.EPOLL_CTL_DEL, e.wakeFd, nil)
+		unix.Close(e.wakeFd)
+		e.wakeFd = -1
+	}
 }
 
 // AddRead 把套接字加入监听，创建conn，并调用OnConnect回调函数
 func (e *Epoll) AddRead(nfd int, c *Conn) error {
-	err := syscall.EpollCtl(e.epollFd, syscall.EPOLL_CTL_ADD, nfd, &syscall.EpollEvent{
-		Events: Epoll_CTL_Read,
-		Fd:     int32(nfd),
-	})
+	err := syscall.EpollCtl(e.epollFd, syscall.EPOLL_CTL_ADD, nfd, newEpollEvent(Epoll_CTL_Read, c.id))
 	if err != nil {
 		return err
 	}
 
-	e.conns.AddConn(nfd, c)
-	e.handler.OnConnect(c)
+	e.conns.Add(c)
+	e.connected(c)
 
 	return nil
 }
 
-// Del 从监听中删除套接字，删除conn，并调用OnClose回调函数
-func (e *Epoll) Del(nfd int) error {
-	err := syscall.EpollCtl(e.epollFd, syscall.EPOLL_CTL_DEL, nfd, nil)
-	if err != nil {
-		return err
+// watchWrite 开始或停止监听套接字的可写事件
+func (e *Epoll) watchWrite(c *Conn, on bool) error {
+	var events uint32 = Epoll_CTL_Read
+	if on {
+		events = Epoll_CTL_Write
 	}
+	return syscall.EpollCtl(e.epollFd, syscall.EPOLL_CTL_MOD, c.fd, newEpollEvent(events, c.id))
+}
 
-	e.handler.OnClose(e.conns.GetConn(nfd))
-	e.conns.DelConn(nfd)
-
+// watchRead 边缘触发，暂停读取期间套接字中剩余的数据不会重复产生事件，不需要修改监听的事件
+func (e *Epoll) watchRead(c *Conn, on bool) error {
 	return nil
 }
 
-// checkTimeout 把在指定时间内一次通信都没有的连接关闭，
-// 因为也许对方由于某些原因已经不使用该连接
-func (e *Epoll) checkTimeout() {
-	for {
-		select {
-		case <-e.ticker.C:
-			e.check()
-		case <-e.stop:
-			break
-		}
+// newEpollEvent 把连接ID保存在epoll_event的data中（Fd与Pad共64位），事件返回时直接得到连接ID，
+// 这样fd被新连接复用后，旧连接还在队列中的事件不会被当作新连接的事件
+func newEpollEvent(events uint32, id uint64) *syscall.EpollEvent {
+	return &syscall.EpollEvent{
+		Events: events,
+		Fd:     int32(uint32(id)),
+		Pad:    int32(uint32(id >> 32)),
 	}
 }
 
-func (e *Epoll) check() {
-	conns := e.conns.Conns()
-	for k, v := range conns {
-		interval := time.Now().Unix() - v.LastTime()
-		if interval < e.interval {
-			continue
-		}
+// epollEventID 从epoll_event的data中取出连接ID
+func epollEventID(ev *syscall.EpollEvent) uint64 {
+	return uint64(uint32(ev.Fd)) | uint64(uint32(ev.Pad))<<32
+}
 
-		e.revents <- event{
-			fd:    int32(k),
-			event: Event_Type_Close,
-		}
+// unwatch 从监听中删除套接字
+func (e *Epoll) unwatch(c *Conn) error {
+	return syscall.EpollCtl(e.epollFd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+}
+
+// Del 从监听中删除套接字，删除conn，调用OnClose回调函数并关闭套接字，与Conn.Close相同
+func (e *Epoll) Del(nfd int) error {
+	c := e.conns.GetConnByFd(nfd)
+	if c == nil {
+		return ErrConnClosed
 	}
+	e.closeConn(c, ErrKicked)
+
+	return nil
 }
@@ -0,0 +1,163 @@
+package go_conn_manager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BroadcastFailure 广播时发送失败的连接及原因
+type BroadcastFailure struct {
+	Conn *Conn
+	Err  error
+}
+
+// BroadcastError 广播时部分连接发送失败，其余连接已发送成功
+type BroadcastError struct {
+	Failures []BroadcastFailure
+}
+
+func (e *BroadcastError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "广播时%d个连接发送失败", len(e.Failures))
+	for i, f := range e.Failures {
+		if i == 3 {
+			sb.WriteString(", ...")
+			break
+		}
+		fmt.Fprintf(&sb, ", 连接%d: %v", f.Conn.id, f.Err)
+	}
+	return sb.String()
+}
+
+// Unwrap 返回各连接发送失败的原因，errors.Is(err, ErrConnClosed)等可以判断是否有连接因该原因失败
+func (e *BroadcastError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// Join 把连接加入组，已在组中时不做处理。连接关闭时自动退出所在的所有组，
+// 已关闭（或正在关闭）的连接不能加入，返回ErrConnClosed；不属于该管理器的连接返回ErrNotManaged
+func (cm *ConnManager) Join(group string, conn *Conn) error {
+	// 其他管理器的连接关闭时不会退出该管理器的组，conn.groups也不由该管理器的锁保护
+	if !cm.owns(conn) {
+		return ErrNotManaged
+	}
+	cm.groupMu.Lock()
+	defer cm.groupMu.Unlock()
+
+	// 关闭流程先标记closing，再在remove中退出所有组，加锁后检查不会遗漏
+	if conn.isClosing() {
+		return ErrConnClosed
+	}
+	members, ok := cm.groups[group]
+	if !ok {
+		members = make(map[uint64]*Conn)
+		cm.groups[group] = members
+	}
+	members[conn.id] = conn
+	if conn.groups == nil {
+		conn.groups = make(map[string]struct{})
+	}
+	conn.groups[group] = struct{}{}
+	return nil
+}
+
+// Leave 把连接移出组，连接不在组中（或不属于该管理器）时返回false。组中没有连接时删除该组
+func (cm *ConnManager) Leave(group string, conn *Conn) bool {
+	if !cm.owns(conn) {
+		return false
+	}
+	cm.groupMu.Lock()
+	defer cm.groupMu.Unlock()
+
+	if _, ok := conn.groups[group]; !ok {
+		return false
+	}
+	cm.leaveLocked(group, conn)
+	return true
+}
+
+func (cm *ConnManager) leaveLocked(group string, conn *Conn) {
+	delete(conn.groups, group)
+	members := cm.groups[group]
+	delete(members, conn.id)
+	if len(members) == 0 {
+		delete(cm.groups, group)
+	}
+}
+
+// leaveAll 连接关闭时退出所在的所有组
+func (cm *ConnManager) leaveAll(conn *Conn) {
+	cm.groupMu.Lock()
+	defer cm.groupMu.Unlock()
+
+	for group := range conn.groups {
+		cm.leaveLocked(group, conn)
+	}
+	conn.groups = nil
+}
+
+// Members 返回组中所有连接的副本，组不存在时返回nil
+func (cm *ConnManager) Members(group string) []*Conn {
+	cm.groupMu.RLock()
+	defer cm.groupMu.RUnlock()
+
+	members := cm.groups[group]
+	if len(members) == 0 {
+		return nil
+	}
+	conns := make([]*Conn, 0, len(members))
+	for _, c := range members {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Groups 返回连接所在的所有组，不属于该管理器的连接返回nil
+func (cm *ConnManager) Groups(conn *Conn) []string {
+	// conn.groups由连接所属管理器的groupMu保护
+	if !cm.owns(conn) {
+		return nil
+	}
+	cm.groupMu.RLock()
+	defer cm.groupMu.RUnlock()
+
+	groups := make([]string, 0, len(conn.groups))
+	for group := range conn.groups {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Broadcast 向组中的所有连接发送msg。msg只封包一次，封包后的数据由所有连接的发送队列共享，
+// 不会为每个连接拷贝。封包失败时返回该错误，不发送给任何连接；部分连接发送失败时返回*BroadcastError，
+// 其余连接不受影响。组中的连接应使用同一Codec（同一多路复用实例的连接都使用其Codec）
+func (cm *ConnManager) Broadcast(group string, msg []byte) error {
+	members := cm.Members(group)
+	if len(members) == 0 {
+		return nil
+	}
+
+	b, err := members[0].codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return broadcast(members, b)
+}
+
+func broadcast(members []*Conn, b []byte) error {
+	var failures []BroadcastFailure
+	for _, c := range members {
+		// 发送队列只读取数据，多个连接可以共享同一块数据
+		if err := c.writeFrames(b, true, 1); err != nil {
+			failures = append(failures, BroadcastFailure{Conn: c, Err: err})
+		}
+	}
+	if len(failures) > 0 {
+		return &BroadcastError{Failures: failures}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package go_conn_manager
+
+import (
+	"testing"
+	"time"
+)
+
+// acceptTestConn 在m上启动服务并连接，返回服务端的Conn
+func acceptTestConn(t *testing.T, m multiplexing) *Conn {
+	t.Helper()
+	connected := make(chan *Conn, 1)
+	addr := startTestServer(t, m, &testHandler{onConnect: func(c *Conn) { connected <- c }})
+	dialTest(t, addr)
+	select {
+	case c := <-connected:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("未调用OnConnect")
+	}
+	return nil
+}
+
+func TestJoinNotManaged(t *testing.T) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			m, other := b.new(), b.new()
+			c := acceptTestConn(t, m)
+			oc := acceptTestConn(t, other)
+			cm := engineOf(m).ConnManager()
+
+			if err := cm.Join("g", oc); err != ErrNotManaged {
+				t.Fatalf("Join其他管理器的连接: %v, 期望ErrNotManaged", err)
+			}
+			if err := cm.Join("g", c); err != nil {
+				t.Fatal(err)
+			}
+			if ms := cm.Members("g"); len(ms) != 1 || ms[0] != c {
+				t.Fatalf("组中的连接: %v", ms)
+			}
+			if gs := cm.Groups(oc); gs != nil {
+				t.Fatalf("其他管理器的连接所在的组: %v", gs)
+			}
+			if cm.Leave("g", oc) {
+				t.Fatal("其他管理器的连接退出了组")
+			}
+		})
+	}
+}
@@ -1,8 +1,52 @@
 package go_conn_manager
 
+import (
+	"context"
+	"net"
+)
+
+// Handler 处理连接事件，每个连接的OnClose与OnError只会调用其中一个，且只调用一次
 type Handler interface {
 	OnConnect(*Conn)         // 创建连接时调用
 	OnMessage(*Conn, []byte) // 套接字有消息可读时调用
-	OnClose(*Conn) error     // 主动关闭连接或超时无心跳包时调用
-	OnError(*Conn)           // 套接字发生了错误，一般是接收到RST
+	OnClose(*Conn) error     // 连接关闭时调用（对方关闭、超时无心跳包或服务端关闭），关闭原因见Conn.CloseReason
+	OnError(*Conn)           // 套接字发生了错误，一般是接收到RST，此时不调用OnClose
+}
+
+// FrameTooLargeHandler Handler可选实现的接口，处理方式为Oversize_Policy_Handler时，
+// 由该方法决定如何处理超出最大长度的数据包，返回Oversize_Policy_Close或Oversize_Policy_Skip
+type FrameTooLargeHandler interface {
+	OnFrameTooLarge(*Conn, *FrameTooLargeError) OversizePolicy
+}
+
+// WritabilityHandler Handler可选实现的接口，连接发送队列中的字节数超过高水位时以false调用，
+// 降到低水位及以下时以true调用，用于暂停与恢复向该连接发送数据
+type WritabilityHandler interface {
+	OnWritabilityChanged(*Conn, bool)
+}
+
+// ContextHandler Handler可选实现的接口，实现时以OnMessageContext代替OnMessage处理消息。
+// ctx为每条消息从该连接的Context（见Conn.Context）派生的Context，连接关闭时被取消（context.Cause为关闭原因），
+// OnMessageContext返回后也被取消。可以从ctx派生出下游调用（数据库、RPC等）使用的Context，
+// 连接关闭后这些调用随之取消；OnMessageContext返回后仍要继续的工作应使用Conn.Context
+type ContextHandler interface {
+	OnMessageContext(ctx context.Context, c *Conn, data []byte)
+}
+
+// ReadTimeoutHandler Handler可选实现的接口，连接读超时（见Conn.SetReadDeadline）时调用，
+// 实现该接口时读超时不会关闭连接，由该方法决定如何处理
+type ReadTimeoutHandler interface {
+	OnReadTimeout(*Conn)
+}
+
+// StateChangeHandler Handler可选实现的接口，连接状态改变时调用，见ConnState。
+// 在改变状态的goroutine中调用，不同连接的通知可能并发
+type StateChangeHandler interface {
+	OnStateChange(c *Conn, from, to ConnState)
+}
+
+// RejectHandler Handler可选实现的接口，新连接被拒绝（超出连接数限制，见ConnLimits；或者被访问控制列表拒绝，见AccessList）并关闭后调用。
+// 此时还没有创建Conn，不会调用OnConnect与OnClose；addr为对方的地址，reason为拒绝的原因
+type RejectHandler interface {
+	OnReject(addr net.Addr, reason error)
 }
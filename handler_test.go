@@ -0,0 +1,52 @@
+package go_conn_manager
+
+import (
+	"context"
+	"testing"
+)
+
+type contextTestHandler struct {
+	testHandler
+	onMessageContext func(context.Context, *Conn, []byte)
+}
+
+func (h *contextTestHandler) OnMessageContext(ctx context.Context, c *Conn, data []byte) {
+	h.onMessageContext(ctx, c, data)
+}
+
+// 每条消息的ctx在OnMessageContext返回后被取消，连接关闭时以关闭原因取消
+func TestMessageContext(t *testing.T) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			ctxs := make(chan context.Context, 2)
+			causes := make(chan error, 2)
+			h := &contextTestHandler{
+				onMessageContext: func(ctx context.Context, c *Conn, data []byte) {
+					if ctx == c.Context() || ctx.Err() != nil {
+						t.Error("ctx不是为该消息派生的Context")
+					}
+					if string(data) == "close" {
+						c.Close()
+						causes <- context.Cause(ctx)
+					}
+					ctxs <- ctx
+					c.Send(data)
+				},
+			}
+			addr := startTestServer(t, b.new(), h)
+
+			c := dialTest(t, addr)
+			writeTestFrame(t, c, []byte("ping"))
+			readTestFrame(t, c)
+			first := <-ctxs
+			if context.Cause(first) != context.Canceled {
+				t.Fatalf("OnMessageContext返回后ctx的取消原因为%v", context.Cause(first))
+			}
+
+			writeTestFrame(t, c, []byte("close"))
+			if cause := <-causes; cause != ErrKicked {
+				t.Fatalf("连接关闭后ctx的取消原因为%v", cause)
+			}
+		})
+	}
+}
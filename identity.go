@@ -0,0 +1,146 @@
+package go_conn_manager
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DuplicateLoginPolicy 同一身份在已绑定连接的情况下再绑定新连接（重复登录）时的处理方式
+type DuplicateLoginPolicy int8
+
+const (
+	Duplicate_Login_Allow      DuplicateLoginPolicy = iota // 允许多个连接（多设备）同时绑定同一身份
+	Duplicate_Login_Kick_Old                               // 关闭原来绑定的连接，关闭前发送SetDuplicateLoginMessage设置的消息
+	Duplicate_Login_Reject_New                             // 拒绝绑定新连接，Bind返回ErrIdentityInUse
+)
+
+var (
+	// ErrIdentityInUse 处理方式为Duplicate_Login_Reject_New时，身份已绑定其他连接
+	ErrIdentityInUse = errors.New("身份已绑定其他连接")
+	// ErrDuplicateLogin 处理方式为Duplicate_Login_Kick_Old时，原来的连接被关闭的原因，errors.Is(err, ErrKicked)也成立
+	ErrDuplicateLogin = fmt.Errorf("同一身份在其他连接登录: %w", ErrKicked)
+)
+
+// SetDuplicateLoginPolicy 设置重复登录时的处理方式，默认为Duplicate_Login_Allow
+func (cm *ConnManager) SetDuplicateLoginPolicy(p DuplicateLoginPolicy) {
+	cm.identMu.Lock()
+	cm.dupPolicy = p
+	cm.identMu.Unlock()
+}
+
+// SetDuplicateLoginMessage 设置处理方式为Duplicate_Login_Kick_Old时，关闭原来的连接前发送给它的消息（经过Codec封包），
+// 为nil时不发送
+func (cm *ConnManager) SetDuplicateLoginMessage(msg []byte) {
+	cm.identMu.Lock()
+	cm.dupMessage = msg
+	cm.identMu.Unlock()
+}
+
+// Bind 把连接绑定到业务身份（如用户ID），之后可以通过Lookup查找。一个连接只能绑定一个身份，
+// 已绑定其他身份时先解除原来的绑定；身份已绑定其他连接时按SetDuplicateLoginPolicy设置的方式处理，
+// 拒绝时返回ErrIdentityInUse，新连接不会被关闭。连接关闭时自动解除绑定，已关闭的连接返回ErrConnClosed，
+// 不属于该管理器的连接返回ErrNotManaged
+func (cm *ConnManager) Bind(userID string, conn *Conn) error {
+	// 其他管理器的连接关闭时不会解除该管理器中的绑定，conn.identity也不由该管理器的锁保护
+	if !cm.owns(conn) {
+		return ErrNotManaged
+	}
+	cm.identMu.Lock()
+	if conn.isClosing() {
+		cm.identMu.Unlock()
+		return ErrConnClosed
+	}
+	if conn.bound && conn.identity == userID {
+		cm.identMu.Unlock()
+		return nil
+	}
+
+	var kicked []*Conn
+	others := cm.identities[userID]
+	if len(others) > 0 {
+		switch cm.dupPolicy {
+		case Duplicate_Login_Reject_New:
+			cm.identMu.Unlock()
+			return ErrIdentityInUse
+		case Duplicate_Login_Kick_Old:
+			for _, c := range others {
+				kicked = append(kicked, c)
+				cm.unbindLocked(c)
+			}
+		}
+	}
+	if conn.bound {
+		cm.unbindLocked(conn)
+	}
+
+	conns, ok := cm.identities[userID]
+	if !ok {
+		conns = make(map[uint64]*Conn)
+		cm.identities[userID] = conns
+	}
+	conns[conn.id] = conn
+	conn.identity = userID
+	conn.bound = true
+	msg := cm.dupMessage
+	cm.identMu.Unlock()
+
+	for _, c := range kicked {
+		if msg != nil {
+			c.Send(msg)
+		}
+		c.closeAfterFlush(ErrDuplicateLogin, Flush_Timeout)
+	}
+	return nil
+}
+
+// Unbind 解除连接与身份的绑定，未绑定（或不属于该管理器）时返回false
+func (cm *ConnManager) Unbind(conn *Conn) bool {
+	if !cm.owns(conn) {
+		return false
+	}
+	cm.identMu.Lock()
+	defer cm.identMu.Unlock()
+
+	if !conn.bound {
+		return false
+	}
+	cm.unbindLocked(conn)
+	return true
+}
+
+func (cm *ConnManager) unbindLocked(conn *Conn) {
+	conns := cm.identities[conn.identity]
+	delete(conns, conn.id)
+	if len(conns) == 0 {
+		delete(cm.identities, conn.identity)
+	}
+	conn.identity = ""
+	conn.bound = false
+}
+
+// Lookup 返回绑定到该身份的所有连接，没有时返回nil
+func (cm *ConnManager) Lookup(userID string) []*Conn {
+	cm.identMu.RLock()
+	defer cm.identMu.RUnlock()
+
+	conns := cm.identities[userID]
+	if len(conns) == 0 {
+		return nil
+	}
+	result := make([]*Conn, 0, len(conns))
+	for _, c := range conns {
+		result = append(result, c)
+	}
+	return result
+}
+
+// Identity 返回连接绑定的身份，未绑定（或不属于该管理器）时ok为false
+func (cm *ConnManager) Identity(conn *Conn) (userID string, ok bool) {
+	if !cm.owns(conn) {
+		return "", false
+	}
+	cm.identMu.RLock()
+	defer cm.identMu.RUnlock()
+
+	return conn.identity, conn.bound
+}
@@ -0,0 +1,34 @@
+package go_conn_manager
+
+import "testing"
+
+func TestBindNotManaged(t *testing.T) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			m, other := b.new(), b.new()
+			c := acceptTestConn(t, m)
+			oc := acceptTestConn(t, other)
+			cm := engineOf(m).ConnManager()
+
+			if err := cm.Bind("u", oc); err != ErrNotManaged {
+				t.Fatalf("Bind其他管理器的连接: %v, 期望ErrNotManaged", err)
+			}
+			if cs := cm.Lookup("u"); cs != nil {
+				t.Fatalf("身份绑定了其他管理器的连接: %v", cs)
+			}
+			if _, ok := cm.Identity(oc); ok {
+				t.Fatal("其他管理器的连接有绑定的身份")
+			}
+			if cm.Unbind(oc) {
+				t.Fatal("解除了其他管理器的连接的绑定")
+			}
+
+			if err := cm.Bind("u", c); err != nil {
+				t.Fatal(err)
+			}
+			if cs := cm.Lookup("u"); len(cs) != 1 || cs[0] != c {
+				t.Fatalf("身份绑定的连接: %v", cs)
+			}
+		})
+	}
+}
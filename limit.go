@@ -0,0 +1,162 @@
+package go_conn_manager
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// LimitPolicy 新连接超出连接数限制时的处理方式
+type LimitPolicy int8
+
+const (
+	Limit_Policy_Close      LimitPolicy = iota // 关闭新连接
+	Limit_Policy_Reset                         // 以RST关闭新连接，不进入TIME_WAIT
+	Limit_Policy_Busy                          // 向新连接发送ConnLimits.BusyMessage后关闭
+	Limit_Policy_Evict_Idle                    // 关闭空闲时间最长的连接以接受新连接，没有可关闭的连接时关闭新连接
+)
+
+var (
+	// ErrConnLimit 超出连接数限制，可用errors.Is判断ErrTooManyConns与ErrTooManyConnsPerIP
+	ErrConnLimit = errors.New("超出连接数限制")
+	// ErrTooManyConns 连接总数超出ConnLimits.MaxConns
+	ErrTooManyConns = fmt.Errorf("%w: 连接总数", ErrConnLimit)
+	// ErrTooManyConnsPerIP 同一IP的连接数超出ConnLimits.MaxConnsPerIP
+	ErrTooManyConnsPerIP = fmt.Errorf("%w: 同一IP的连接数", ErrConnLimit)
+	// ErrEvicted 处理方式为Limit_Policy_Evict_Idle时，空闲连接为接受新连接而被关闭的原因，errors.Is(err, ErrKicked)也成立
+	ErrEvicted = fmt.Errorf("为接受新连接关闭空闲连接: %w", ErrKicked)
+)
+
+// ConnLimits 连接数限制，在接受连接后、创建Conn前检查
+type ConnLimits struct {
+	MaxConns      int         // 连接总数的上限，为0时不限制
+	MaxConnsPerIP int         // 同一IP的连接数的上限，为0时不限制
+	Policy        LimitPolicy // 超出限制时的处理方式
+	BusyMessage   []byte      // 处理方式为Limit_Policy_Busy时发送的消息，经过Codec封包
+	// 处理方式为Limit_Policy_Evict_Idle时，只关闭空闲时间不少于该值的连接
+	MinIdle time.Duration
+}
+
+// AdmissionStats 接受新连接的统计
+type AdmissionStats struct {
+	Rejected      uint64 // 因超出连接数限制被关闭的新连接数
+	RejectedPerIP uint64 // 其中因超出同一IP的连接数限制被关闭的
+	Evicted       uint64 // 为接受新连接而关闭的空闲连接数
+	Denied        uint64 // 被访问控制列表拒绝的新连接数，见AccessList
+}
+
+type admissionStats struct {
+	rejected      atomic.Uint64
+	rejectedPerIP atomic.Uint64
+	evicted       atomic.Uint64
+	denied        atomic.Uint64
+}
+
+// SetConnLimits 设置连接数限制，需要在Init之前调用，默认不限制
+func (en *engine) SetConnLimits(l ConnLimits) {
+	en.connLimits = &l
+}
+
+// AdmissionStats 返回接受新连接的统计，用于监控
+func (en *engine) AdmissionStats() AdmissionStats {
+	return AdmissionStats{
+		Rejected:      en.admission.rejected.Load(),
+		RejectedPerIP: en.admission.rejectedPerIP.Load(),
+		Evicted:       en.admission.evicted.Load(),
+		Denied:        en.admission.denied.Load(),
+	}
+}
+
+// admit 按访问控制列表检查新接受的套接字，再检查是否超出连接数限制，
+// 超出时按处理方式关闭该套接字或者关闭空闲连接。返回false时nfd已关闭
+func (en *engine) admit(nfd int, sa syscall.Sockaddr) bool {
+	if !en.permit(nfd, sa) {
+		return false
+	}
+
+	l := en.connLimits
+	if l == nil {
+		return true
+	}
+
+	ip := sockaddrToAddrPort(sa).Addr().Unmap()
+	var reason error
+	if l.MaxConns > 0 && en.conns.Len() >= l.MaxConns {
+		reason = ErrTooManyConns
+	} else if l.MaxConnsPerIP > 0 && ip.IsValid() && en.conns.LenByIP(ip) >= l.MaxConnsPerIP {
+		reason = ErrTooManyConnsPerIP
+	}
+	if reason == nil {
+		return true
+	}
+
+	if l.Policy == Limit_Policy_Evict_Idle && en.evictIdle(reason, ip, l.MinIdle) {
+		return true
+	}
+	en.reject(nfd, sa, l, reason)
+	return false
+}
+
+// evictIdle 关闭空闲时间最长的连接，超出同一IP的限制时只在该IP的连接中选择
+func (en *engine) evictIdle(reason error, ip netip.Addr, minIdle time.Duration) bool {
+	var candidates []*Conn
+	if reason == ErrTooManyConnsPerIP {
+		candidates = en.conns.ConnsByIP(ip)
+	} else {
+		// 需要遍历所有连接，只在超出限制时执行
+		en.conns.Range(func(c *Conn) bool {
+			candidates = append(candidates, c)
+			return true
+		})
+	}
+
+	var victim *Conn
+	var longest time.Duration
+	for _, c := range candidates {
+		if idle := c.idleFor(); idle >= minIdle && (victim == nil || idle > longest) {
+			victim, longest = c, idle
+		}
+	}
+	if victim == nil {
+		return false
+	}
+
+	en.admission.evicted.Add(1)
+	en.closeConn(victim, ErrEvicted)
+	return true
+}
+
+// reject 按处理方式关闭超出连接数限制的套接字
+func (en *engine) reject(nfd int, sa syscall.Sockaddr, l *ConnLimits, reason error) {
+	en.admission.rejected.Add(1)
+	if reason == ErrTooManyConnsPerIP {
+		en.admission.rejectedPerIP.Add(1)
+	}
+
+	switch l.Policy {
+	case Limit_Policy_Reset:
+		unix.SetsockoptLinger(nfd, unix.SOL_SOCKET, unix.SO_LINGER, &unix.Linger{Onoff: 1})
+	case Limit_Policy_Busy:
+		// 新连接的发送缓冲区为空，一般可以一次写完；写不完时丢弃，不等待
+		if l.BusyMessage != nil {
+			if b, err := en.codec.Encode(l.BusyMessage); err == nil {
+				writeFd(nfd, b)
+			}
+		}
+	}
+	en.rejected(nfd, sa, reason)
+}
+
+// rejected 关闭被拒绝的套接字，并调用RejectHandler.OnReject
+func (en *engine) rejected(nfd int, sa syscall.Sockaddr, reason error) {
+	syscall.Close(nfd)
+
+	if h, ok := en.handler.(RejectHandler); ok {
+		h.OnReject(sockaddrToAddr(sa), reason)
+	}
+}
@@ -0,0 +1,156 @@
+package go_conn_manager
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type rejectTestHandler struct {
+	testHandler
+	onReject func(net.Addr, error)
+}
+
+func (h *rejectTestHandler) OnReject(addr net.Addr, reason error) {
+	h.onReject(addr, reason)
+}
+
+// expectEOF 对方关闭连接后读到io.EOF
+func expectEOF(t *testing.T, c net.Conn) {
+	t.Helper()
+	if n, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("读取返回%d, %v, 期望io.EOF", n, err)
+	}
+}
+
+// 第二个连接超出限制时按各处理方式处理，并调用OnReject、更新AdmissionStats
+func TestConnLimits(t *testing.T) {
+	busy := []byte("busy")
+	tests := []struct {
+		name   string
+		limits ConnLimits
+		reason error // OnReject的原因，为nil时不应拒绝
+		stats  AdmissionStats
+		// check 检查第二个连接在客户端的表现
+		check func(t *testing.T, c net.Conn)
+	}{
+		{
+			name:   "close",
+			limits: ConnLimits{MaxConns: 1, Policy: Limit_Policy_Close},
+			reason: ErrTooManyConns,
+			stats:  AdmissionStats{Rejected: 1},
+			check:  expectEOF,
+		},
+		{
+			name:   "close per ip",
+			limits: ConnLimits{MaxConnsPerIP: 1, Policy: Limit_Policy_Close},
+			reason: ErrTooManyConnsPerIP,
+			stats:  AdmissionStats{Rejected: 1, RejectedPerIP: 1},
+			check:  expectEOF,
+		},
+		{
+			name:   "reset",
+			limits: ConnLimits{MaxConns: 1, Policy: Limit_Policy_Reset},
+			reason: ErrTooManyConns,
+			stats:  AdmissionStats{Rejected: 1},
+			check: func(t *testing.T, c net.Conn) {
+				if _, err := c.Read(make([]byte, 1)); !errors.Is(err, syscall.ECONNRESET) {
+					t.Fatalf("读取返回%v, 期望ECONNRESET", err)
+				}
+			},
+		},
+		{
+			name:   "busy",
+			limits: ConnLimits{MaxConns: 1, Policy: Limit_Policy_Busy, BusyMessage: busy},
+			reason: ErrTooManyConns,
+			stats:  AdmissionStats{Rejected: 1},
+			check: func(t *testing.T, c net.Conn) {
+				if got := readTestFrame(t, c); !bytes.Equal(got, busy) {
+					t.Fatalf("收到%q, 期望%q", got, busy)
+				}
+				expectEOF(t, c)
+			},
+		},
+		{
+			name:   "evict idle",
+			limits: ConnLimits{MaxConns: 1, Policy: Limit_Policy_Evict_Idle},
+			stats:  AdmissionStats{Evicted: 1},
+		},
+		{
+			name:   "evict idle none idle enough",
+			limits: ConnLimits{MaxConns: 1, Policy: Limit_Policy_Evict_Idle, MinIdle: time.Hour},
+			reason: ErrTooManyConns,
+			stats:  AdmissionStats{Rejected: 1},
+			check:  expectEOF,
+		},
+	}
+
+	for _, b := range testBackends {
+		for _, tt := range tests {
+			t.Run(b.name+"/"+tt.name, func(t *testing.T) {
+				m := b.new()
+				engineOf(m).SetConnLimits(tt.limits)
+				connected := make(chan *Conn, 2)
+				closed := make(chan *Conn, 2)
+				rejected := make(chan error, 2)
+				addr := startTestServer(t, m, &rejectTestHandler{
+					testHandler: testHandler{
+						onConnect: func(c *Conn) { connected <- c },
+						onClose:   func(c *Conn) { closed <- c },
+					},
+					onReject: func(_ net.Addr, reason error) { rejected <- reason },
+				})
+				accepted := func() *Conn {
+					t.Helper()
+					select {
+					case c := <-connected:
+						return c
+					case <-time.After(5 * time.Second):
+						t.Fatal("未调用OnConnect")
+					}
+					return nil
+				}
+
+				first := dialTest(t, addr)
+				c1 := accepted()
+				second := dialTest(t, addr)
+
+				if tt.reason != nil {
+					select {
+					case reason := <-rejected:
+						if reason != tt.reason {
+							t.Fatalf("OnReject的原因为%v, 期望%v", reason, tt.reason)
+						}
+					case <-time.After(5 * time.Second):
+						t.Fatal("未调用OnReject")
+					}
+					tt.check(t, second)
+				} else {
+					accepted()
+					select {
+					case c := <-closed:
+						if c != c1 || c.CloseReason() != ErrEvicted {
+							t.Fatalf("关闭了连接%d, 原因为%v, 期望以ErrEvicted关闭连接%d", c.ID(), c.CloseReason(), c1.ID())
+						}
+					case <-time.After(5 * time.Second):
+						t.Fatal("未关闭空闲连接")
+					}
+					expectEOF(t, first)
+					select {
+					case reason := <-rejected:
+						t.Fatalf("接受新连接时调用了OnReject: %v", reason)
+					default:
+					}
+				}
+
+				if got := engineOf(m).AdmissionStats(); got != tt.stats {
+					t.Fatalf("AdmissionStats为%+v, 期望%+v", got, tt.stats)
+				}
+			})
+		}
+	}
+}
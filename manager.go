@@ -1,53 +1,368 @@
 package go_conn_manager
 
 import (
+	"errors"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type ConnManager struct {
+const (
+	Conn_Manager_Shards = 64 // ConnManager的分片数，必须为2的幂
+)
+
+// ErrNotManaged 连接不属于该管理器（由其他多路复用实例接受）
+var ErrNotManaged = errors.New("连接不属于该管理器")
+
+// connShard ConnManager的一个分片，conns按连接ID分片，fds按fd分片，
+// 同一连接的两个索引不一定在同一分片中
+type connShard struct {
 	mu    sync.RWMutex
-	conns map[int]*Conn
-	stop  chan struct{}
+	conns map[uint64]*Conn // 以连接ID为key
+	fds   map[int]*Conn    // fd到连接的索引，供多路复用实例根据fd查找连接
+}
+
+// ConnManager 管理连接，按连接ID分为Conn_Manager_Shards个分片，各分片有各自的锁，
+// 连接数很多时增删查不会争用同一把锁。所有方法都可以并发调用
+type ConnManager struct {
+	shards [Conn_Manager_Shards]connShard
+	count  atomic.Int64
+
+	interval  time.Duration // 连接默认的空闲超时时间，为0时不检测
+	wheel     timingWheel   // 检测空闲超时的连接
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+
+	groupMu sync.RWMutex                // 保护groups以及各连接的Conn.groups
+	groups  map[string]map[uint64]*Conn // 组名到组中的连接
+
+	identMu    sync.RWMutex                // 保护identities、重复登录的配置以及各连接绑定的身份
+	identities map[string]map[uint64]*Conn // 身份到绑定的连接
+	dupPolicy  DuplicateLoginPolicy
+	dupMessage []byte
+
+	ipMu sync.Mutex                      // 保护ips
+	ips  map[netip.Addr]map[uint64]*Conn // 对方的IP到该IP的连接，用于限制同一IP的连接数
+
+	statsMu     sync.Mutex
+	closedStats TrafficStats // 已删除的连接的流量合计，由statsMu保护
 }
 
-// NewConnManager 生成一个实例
+// NewConnManager 生成一个实例，interval为连接的空闲超时时间，超过该时间没有读到数据的连接
+// 以ErrIdleTimeout关闭，单个连接可以通过Conn.SetIdleTimeout修改；为0时不检测
 func NewConnManager(interval time.Duration) *ConnManager {
-	return &ConnManager{
-		conns: make(map[int]*Conn),
-		stop:  make(chan struct{}),
+	cm := &ConnManager{
+		interval:   interval,
+		stop:       make(chan struct{}),
+		groups:     make(map[string]map[uint64]*Conn),
+		identities: make(map[string]map[uint64]*Conn),
+		ips:        make(map[netip.Addr]map[uint64]*Conn),
+	}
+	for i := range cm.shards {
+		cm.shards[i].conns = make(map[uint64]*Conn)
+		cm.shards[i].fds = make(map[int]*Conn)
+	}
+	cm.wheel.init(Timing_Wheel_Tick)
+	return cm
+}
+
+func (cm *ConnManager) shardByID(id uint64) *connShard {
+	return &cm.shards[id&(Conn_Manager_Shards-1)]
+}
+
+func (cm *ConnManager) shardByFd(fd int) *connShard {
+	return &cm.shards[uint(fd)&(Conn_Manager_Shards-1)]
+}
+
+// Add 添加Conn实例到管理器中。若该fd已关联其他Conn实例，说明fd已被复用，
+// 原来的套接字已经关闭，原来的Conn实例已失效，只标记为已关闭并删除，
+// 不能再关闭该fd，否则关闭的是新的连接
+func (cm *ConnManager) Add(conn *Conn) {
+	fs := cm.shardByFd(conn.fd)
+	fs.mu.Lock()
+	stale, ok := fs.fds[conn.fd]
+	fs.fds[conn.fd] = conn
+	fs.mu.Unlock()
+
+	if ok && stale != conn {
+		stale.markClosed()
+		stale.setState(Conn_State_Closed)
+		stale.cancel(ErrConnClosed)
+		cm.detach(stale)
+	}
+
+	s := cm.shardByID(conn.id)
+	s.mu.Lock()
+	if _, ok := s.conns[conn.id]; !ok {
+		s.conns[conn.id] = conn
+		cm.count.Add(1)
+		// 在分片的锁内更新IP的索引，与detach中的删除不会交错
+		cm.trackIP(conn)
+	}
+	s.mu.Unlock()
+
+	cm.wheel.register(&conn.idle)
+	cm.scheduleIdle(conn)
+	cm.startOnce.Do(func() {
+		go cm.runWheel()
+	})
+}
+
+// AddConn 与Add相同
+//
+// Deprecated: 使用Add
+func (cm *ConnManager) AddConn(conn *Conn) {
+	cm.Add(conn)
+}
+
+// Delete 关闭指定ID的连接，关闭原因为ErrKicked，连接不存在时返回false。
+// 连接只能通过关闭从管理器中删除，见Kick
+func (cm *ConnManager) Delete(id uint64) bool {
+	c := cm.Get(id)
+	if c == nil {
+		return false
+	}
+	cm.Kick(c, ErrKicked)
+	return true
+}
+
+// DelConn 关闭指定ID的连接
+//
+// Deprecated: 使用Delete
+func (cm *ConnManager) DelConn(id uint64) {
+	cm.Delete(id)
+}
+
+// Kick 关闭连接：停止监听该套接字、从管理器中删除、调用OnClose并关闭套接字，
+// reason为关闭原因，为nil时使用ErrKicked。可以重复调用，只有第一次生效
+func (cm *ConnManager) Kick(conn *Conn, reason error) {
+	if reason == nil {
+		reason = ErrKicked
+	}
+	conn.en.closeConn(conn, reason)
+}
+
+// owns conn是否由使用该管理器的多路复用实例接受
+func (cm *ConnManager) owns(conn *Conn) bool {
+	return conn.en != nil && conn.en.conns == cm
+}
+
+// remove 从管理器中删除conn
+func (cm *ConnManager) remove(conn *Conn) {
+	cm.detach(conn)
+
+	fs := cm.shardByFd(conn.fd)
+	fs.mu.Lock()
+	if c, ok := fs.fds[conn.fd]; ok && c == conn {
+		delete(fs.fds, conn.fd)
+	}
+	fs.mu.Unlock()
+}
+
+// detach 从时间轮、组、身份与IP的索引中删除conn，再从连接ID的索引中删除，并把其流量计入已删除连接的合计
+func (cm *ConnManager) detach(conn *Conn) {
+	cm.wheel.unregister(&conn.idle)
+	cm.leaveAll(conn)
+	cm.Unbind(conn)
+
+	s := cm.shardByID(conn.id)
+	s.mu.Lock()
+	_, ok := s.conns[conn.id]
+	if ok {
+		delete(s.conns, conn.id)
+		cm.untrackIP(conn)
 	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	cm.count.Add(-1)
+	cm.statsMu.Lock()
+	cm.closedStats.add(conn.stats.traffic())
+	cm.statsMu.Unlock()
+}
+
+// remoteIP 返回连接对方的IP，IPv4映射的IPv6地址转换为IPv4地址
+func remoteIP(c *Conn) netip.Addr {
+	return c.RemoteAddrPort().Addr().Unmap()
+}
+
+func (cm *ConnManager) trackIP(conn *Conn) {
+	ip := remoteIP(conn)
+	if !ip.IsValid() {
+		return
+	}
+
+	cm.ipMu.Lock()
+	defer cm.ipMu.Unlock()
+
+	conns, ok := cm.ips[ip]
+	if !ok {
+		conns = make(map[uint64]*Conn)
+		cm.ips[ip] = conns
+	}
+	conns[conn.id] = conn
+}
+
+func (cm *ConnManager) untrackIP(conn *Conn) {
+	ip := remoteIP(conn)
+	if !ip.IsValid() {
+		return
+	}
+
+	cm.ipMu.Lock()
+	defer cm.ipMu.Unlock()
+
+	conns := cm.ips[ip]
+	delete(conns, conn.id)
+	if len(conns) == 0 {
+		delete(cm.ips, ip)
+	}
+}
+
+// LenByIP 返回来自该IP的连接数
+func (cm *ConnManager) LenByIP(ip netip.Addr) int {
+	cm.ipMu.Lock()
+	defer cm.ipMu.Unlock()
+
+	return len(cm.ips[ip.Unmap()])
+}
+
+// ConnsByIP 返回来自该IP的所有连接
+func (cm *ConnManager) ConnsByIP(ip netip.Addr) []*Conn {
+	cm.ipMu.Lock()
+	defer cm.ipMu.Unlock()
+
+	conns := cm.ips[ip.Unmap()]
+	result := make([]*Conn, 0, len(conns))
+	for _, c := range conns {
+		result = append(result, c)
+	}
+	return result
+}
+
+// Get 获取指定ID的Conn实例，不存在时返回nil
+func (cm *ConnManager) Get(id uint64) *Conn {
+	s := cm.shardByID(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.conns[id]
+}
+
+// GetConn 与Get相同
+//
+// Deprecated: 使用Get
+func (cm *ConnManager) GetConn(id uint64) *Conn {
+	return cm.Get(id)
+}
+
+// GetConnByFd 获取当前使用该fd的Conn实例
+func (cm *ConnManager) GetConnByFd(fd int) *Conn {
+	s := cm.shardByFd(fd)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.fds[fd]
+}
+
+// Len 返回连接数
+func (cm *ConnManager) Len() int {
+	return int(cm.count.Load())
 }
 
-// AddConn 添加指定key的Conn实例到管理器中，若该key已关联Conn实例，则被更换为新的
-func (cm *ConnManager) AddConn(key int, conn *Conn) {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+// Range 对每个连接调用f，f返回false时停止遍历。逐个分片复制连接后在锁外调用f，
+// f中可以关闭连接或者调用管理器的其他方法；遍历过程中增删的连接可能不会被遍历到
+func (cm *ConnManager) Range(f func(*Conn) bool) {
+	var conns []*Conn
+	for i := range cm.shards {
+		s := &cm.shards[i]
+		s.mu.RLock()
+		conns = conns[:0]
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.mu.RUnlock()
 
-	// 关闭连接，删除记录
-	if c, ok := cm.conns[key]; ok {
-		c.Close()
-		delete(cm.conns, key)
+		for _, c := range conns {
+			if !f(c) {
+				return
+			}
+		}
 	}
-	cm.conns[key] = conn
 }
 
-// DelConn 删除指定key的Conn实例
-func (cm *ConnManager) DelConn(key int) {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+// Conns 返回所有连接的副本
+//
+// Deprecated: 连接数很多时开销较大，使用Range
+func (cm *ConnManager) Conns() map[uint64]*Conn {
+	conns := make(map[uint64]*Conn, cm.Len())
+	cm.Range(func(c *Conn) bool {
+		conns[c.id] = c
+		return true
+	})
+	return conns
+}
+
+// idleTimeout 返回连接的空闲超时时间，为0时不检测
+func (cm *ConnManager) idleTimeout(c *Conn) time.Duration {
+	if d := c.IdleTimeout(); d > 0 {
+		return d
+	}
+	return cm.interval
+}
+
+// scheduleIdle 按最后一次读到数据的时间把连接加入时间轮。
+// 读到数据时只更新时间，不需要调整时间轮，到期时再按实际的时间判断
+func (cm *ConnManager) scheduleIdle(c *Conn) {
+	d := cm.idleTimeout(c)
+	if d <= 0 {
+		cm.wheel.remove(&c.idle)
+		return
+	}
+	cm.wheel.add(&c.idle, c.stats.lastRead.Load()+int64(d))
+}
+
+func (cm *ConnManager) runWheel() {
+	ticker := time.NewTicker(cm.wheel.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cm.expireIdle()
+		case <-cm.stop:
+			return
+		}
+	}
+}
 
-	if c, ok := cm.conns[key]; ok {
-		c.Close()
-		delete(cm.conns, key)
+// expireIdle 处理时间轮中到期的连接：期间没有读到数据的连接以ErrIdleTimeout关闭，否则重新加入时间轮
+func (cm *ConnManager) expireIdle() {
+	for _, c := range cm.wheel.advance(time.Now().UnixNano()) {
+		d := cm.idleTimeout(c)
+		if d <= 0 {
+			continue
+		}
+		if c.idleFor() >= d {
+			cm.Kick(c, ErrIdleTimeout)
+		} else {
+			cm.scheduleIdle(c)
+		}
 	}
 }
 
-// GetConn 获取指定key关联的Conn实例
-func (cm *ConnManager) GetConn(key int) *Conn {
-	return cm.conns[key]
+// stopWheel 停止检测空闲超时
+func (cm *ConnManager) stopWheel() {
+	cm.stopOnce.Do(func() {
+		close(cm.stop)
+	})
 }
 
-func (cm *ConnManager) Conns() map[int]*Conn {
-	return cm.conns
+// ConnManager 返回该多路复用实例的连接管理器
+func (en *engine) ConnManager() *ConnManager {
+	return en.conns
 }
@@ -0,0 +1,92 @@
+package go_conn_manager
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// newManagerTestConn 生成只用于ConnManager的Conn实例，不关联套接字
+func newManagerTestConn(id uint64, fd int) *Conn {
+	sa := &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}, Port: 1000 + fd}
+	c := &Conn{
+		id:       id,
+		fd:       fd,
+		SockAddr: sa,
+		addrPort: sockaddrToAddrPort(sa),
+	}
+	c.idle.conn = c
+	return c
+}
+
+// 并发增删连接的同时调用Range与Get，结束后Len、Range与按IP的索引应一致
+func TestConnManagerConcurrent(t *testing.T) {
+	const (
+		workers = 8
+		rounds  = 500
+		keep    = 10 // 每个goroutine每keep个连接保留一个
+	)
+	cm := NewConnManager(0)
+	t.Cleanup(cm.stopWheel)
+
+	var done atomic.Bool
+	var readers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for !done.Load() {
+				cm.Range(func(c *Conn) bool {
+					if got := cm.Get(c.id); got != nil && got != c {
+						t.Errorf("连接%d: Get返回了其他连接", c.id)
+					}
+					return true
+				})
+				if l := cm.Len(); l < 0 || l > workers*rounds {
+					t.Errorf("Len为%d", l)
+				}
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < rounds; i++ {
+				id := uint64(w*rounds + i + 1)
+				c := newManagerTestConn(id, int(id))
+				cm.Add(c)
+				if cm.Get(id) != c || cm.GetConnByFd(c.fd) != c {
+					t.Errorf("连接%d: 添加后查找不到", id)
+				}
+				if i%keep == 0 {
+					continue
+				}
+				cm.remove(c)
+				if cm.Get(id) != nil || cm.GetConnByFd(c.fd) != nil {
+					t.Errorf("连接%d: 删除后仍能查找到", id)
+				}
+			}
+		}(w)
+	}
+	writers.Wait()
+	done.Store(true)
+	readers.Wait()
+
+	want := workers * rounds / keep
+	n := 0
+	cm.Range(func(*Conn) bool {
+		n++
+		return true
+	})
+	if cm.Len() != want || n != want {
+		t.Fatalf("Len为%d, Range遍历了%d个连接, 期望%d", cm.Len(), n, want)
+	}
+	if l := cm.LenByIP(netip.MustParseAddr("127.0.0.1")); l != want {
+		t.Fatalf("LenByIP为%d, 期望%d", l, want)
+	}
+}
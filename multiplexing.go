@@ -1,23 +1,327 @@
 package go_conn_manager
 
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
 type eventType int8
 
 const (
 	Event_Type_Connect eventType = iota
-	Event_Type_Close
+	Event_Type_Close             // 未使用，对方关闭由读到EOF处理，保留以免改变之后的常量值
 	Event_Type_In
 	Event_Type_Error
+	Event_Type_Out
+)
+
+const (
+	Write_Low_Watermark  = 32 * 1024 // 发送队列默认的低水位
+	Write_High_Watermark = 64 * 1024 // 发送队列默认的高水位
+
+	Flush_Timeout = 5 * time.Second // 对方关闭写端后，等待发送队列中的数据发送完的最长时间
+)
+
+// OversizePolicy 接收到超出最大长度的数据包时的处理方式
+type OversizePolicy int8
+
+const (
+	Oversize_Policy_Close   OversizePolicy = iota // 关闭连接
+	Oversize_Policy_Skip                          // 丢弃该数据包，继续处理后面的数据包
+	Oversize_Policy_Handler                       // 由FrameTooLargeHandler决定，Handler未实现该接口时关闭连接
 )
 
+// event 多路复用实例产生的事件，连接的事件以连接ID标识，fd只用于监听套接字
 type event struct {
 	fd    int32
+	id    uint64
 	event eventType
+	hup   bool // 可读事件中对方已关闭写端
 }
 
 type multiplexing interface {
 	SetHandler(h Handler)
+	SetCodec(c Codec)
+	Codec() Codec
 	Init(ipAddr string, port int) error
 	WaitEvent()
 	HandleEvent() error
 	Stop()
 }
+
+// poller 由Epoll与Poll实现，用于修改套接字监听的事件
+type poller interface {
+	// watchWrite 开始或停止监听套接字的可写事件
+	watchWrite(c *Conn, on bool) error
+	// watchRead 开始或停止监听套接字的可读事件，用于暂停读取，见Conn.pauseRead
+	watchRead(c *Conn, on bool) error
+	// unwatch 停止监听套接字
+	unwatch(c *Conn) error
+}
+
+// engine Epoll与Poll共用的部分，保存该多路复用实例的配置与连接
+type engine struct {
+	poller    poller
+	handler   Handler
+	onMessage HandleMessage // 处理消息的函数，Handler实现了ContextHandler时调用OnMessageContext
+	codec     Codec
+	conns     *ConnManager
+	revents   chan event
+
+	listenConfig  *ListenConfig              // 为nil时使用DefaultListenConfig
+	socketOptions *SocketOptions             // 为nil时使用DefaultSocketOptions
+	connLimits    *ConnLimits                // 为nil时不限制连接数
+	acl           atomic.Pointer[AccessList] // 为nil时不检查，可以在运行时替换
+	rateLimit     *RateLimit                 // 为nil时不限制新连接的速率
+	admission     admissionStats
+
+	oversizePolicy  OversizePolicy
+	oversizedFrames uint64 // 接收到的超出最大长度的数据包数
+	lowWatermark    int
+	highWatermark   int
+}
+
+func (en *engine) SetHandler(h Handler) {
+	en.handler = h
+	en.onMessage = h.OnMessage
+	if ch, ok := h.(ContextHandler); ok {
+		en.onMessage = func(c *Conn, data []byte) {
+			ctx, cancel := context.WithCancelCause(c.ctx)
+			defer cancel(nil)
+			ch.OnMessageContext(ctx, c, data)
+		}
+	}
+}
+
+// SetCodec 设置该实例使用的编解码器，需要在Init之前调用
+func (en *engine) SetCodec(c Codec) {
+	en.codec = c
+}
+
+func (en *engine) Codec() Codec {
+	return en.codec
+}
+
+// SetOversizePolicy 设置接收到超出最大长度的数据包时的处理方式，默认关闭连接
+func (en *engine) SetOversizePolicy(p OversizePolicy) {
+	en.oversizePolicy = p
+}
+
+// SetWriteWatermark 设置新连接发送队列的低水位与高水位（字节数），
+// 默认为Write_Low_Watermark与Write_High_Watermark
+func (en *engine) SetWriteWatermark(low, high int) {
+	en.lowWatermark = low
+	en.highWatermark = high
+}
+
+// OversizedFrames 返回接收到的超出最大长度的数据包数，用于监控
+func (en *engine) OversizedFrames() uint64 {
+	return atomic.LoadUint64(&en.oversizedFrames)
+}
+
+// acceptAll 接受监听套接字上所有等待中的连接，并由add加入监听。
+// 监听套接字为边缘触发时，一次事件可能对应多个连接，需要接受到EAGAIN为止
+func (en *engine) acceptAll(listenFd int, add func(nfd int, c *Conn) error) {
+	for {
+		// 写数据时缓冲区已满则放入发送队列，而不是阻塞
+		nfd, sa, err := syscall.Accept4(listenFd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+		if err != nil {
+			// 连接在接受前已被对方重置时继续接受下一个连接
+			if err == syscall.EINTR || err == syscall.ECONNABORTED {
+				continue
+			}
+			// EAGAIN：没有等待中的连接；EMFILE等错误：等下一次事件再接受
+			return
+		}
+		if !en.admit(nfd, sa) {
+			continue
+		}
+
+		c, err := en.initConn(nfd, sa)
+		if err == nil {
+			err = add(nfd, c)
+		}
+		if err != nil {
+			if c != nil {
+				c.cancel(err)
+			}
+			syscall.Close(nfd)
+		}
+	}
+}
+
+// initConn 为新接受的套接字创建Conn实例，并按SocketOptions设置套接字选项
+func (en *engine) initConn(nfd int, sa syscall.Sockaddr) (*Conn, error) {
+	lsa, err := syscall.Getsockname(nfd)
+	if err != nil {
+		return nil, err
+	}
+
+	c := en.newConn(nfd, sa, lsa)
+	return c, en.setSocketOptions(c)
+}
+
+// newConn 为新接受的套接字创建Conn实例，sa与lsa分别为对方与本地的地址
+func (en *engine) newConn(nfd int, sa, lsa syscall.Sockaddr) *Conn {
+	low, high := en.lowWatermark, en.highWatermark
+	if high == 0 {
+		low, high = Write_Low_Watermark, Write_High_Watermark
+	}
+
+	c := &Conn{
+		id:            atomic.AddUint64(&lastConnID, 1),
+		fd:            nfd,
+		SockAddr:      sa,
+		remote:        sockaddrToAddr(sa),
+		addrPort:      sockaddrToAddrPort(sa),
+		local:         sockaddrToAddr(lsa),
+		en:            en,
+		codec:         en.codec,
+		writable:      true,
+		lowWatermark:  low,
+		highWatermark: high,
+		notified:      true,
+	}
+	c.ctx, c.cancel = context.WithCancelCause(context.Background())
+	c.idle.conn = c
+	if en.rateLimit != nil {
+		c.limiter.Store(newRateLimiter(*en.rateLimit))
+	}
+	now := time.Now().UnixNano()
+	c.stats.connectTime = now
+	c.stats.lastRead.Store(now)
+	return c
+}
+
+// handleIn 处理可读事件，同一连接同时只有一个goroutine在读取，
+// 读取过程中到达的事件由该goroutine再次读取，而不是创建新的goroutine
+func (en *engine) handleIn(ev event) {
+	c := en.conns.Get(ev.id)
+	if c == nil {
+		return
+	}
+	if ev.hup {
+		// 需要读到EOF，见UnpackFromFD
+		atomic.StoreInt32(&c.peerHup, 1)
+	}
+	if atomic.AddInt32(&c.reading, 1) > 1 {
+		return
+	}
+
+	go func() {
+		for {
+			n := atomic.LoadInt32(&c.reading)
+			if c.isClosed() {
+				// 读取过程中连接被关闭，由这里关闭套接字，见Conn.closeFd
+				syscall.Close(c.fd)
+				return
+			}
+
+			err := UnpackFromFD(c, en.onMessage)
+			var fe *FrameTooLargeError
+			if errors.As(err, &fe) && en.skipFrame(c, fe) {
+				continue
+			}
+			if err == ErrRateLimited {
+				en.closeConn(c, ErrRateLimited)
+			} else if err == io.EOF {
+				// 对方关闭了套接字（或只关闭了写端），之后不会再有数据可读，停止监听可读事件，
+				// 只监听可写事件直到发送完或者关闭；否则水平触发的Poll在等待发送期间每次都会立即返回POLLRDHUP
+				en.poller.watchRead(c, false)
+				// 包装为NetConn时与TCP的半关闭相同：NetConn.Read返回io.EOF，仍可以继续Write，由NetConn.Close或CloseWrite关闭，
+				// 关闭写端后对方也关闭时会产生HUP事件，再次读到EOF后关闭；
+				// 否则此时收到的数据包都已处理，等发送给对方的数据发送完后再关闭
+				if c.stream.Load() == nil || c.writeClosed() {
+					c.closeAfterFlush(ErrPeerClosed, Flush_Timeout)
+				}
+			} else if fe != nil {
+				en.closeConn(c, fe)
+			} else if errors.Is(err, ErrMalformedFrame) {
+				// 协议错误，以该错误为原因关闭，而不是当作接收到RST
+				en.closeConn(c, err)
+			} else if err != nil {
+				// 读取出错，一般是接收到RST
+				en.closeConn(c, ErrConnReset)
+			}
+			if atomic.AddInt32(&c.reading, -n) == 0 {
+				return
+			}
+		}
+	}()
+}
+
+// skipFrame 按配置的处理方式处理超出最大长度的数据包，返回true时已设置跳过该数据包，
+// 返回false时需要关闭连接
+func (en *engine) skipFrame(c *Conn, fe *FrameTooLargeError) bool {
+	atomic.AddUint64(&en.oversizedFrames, 1)
+	c.stats.oversizedFrames.Add(1)
+
+	policy := en.oversizePolicy
+	if policy == Oversize_Policy_Handler {
+		policy = Oversize_Policy_Close
+		if h, ok := en.handler.(FrameTooLargeHandler); ok {
+			policy = h.OnFrameTooLarge(c, fe)
+		}
+	}
+	// 长度未知的数据包无法跳过
+	if policy != Oversize_Policy_Skip || fe.Size <= 0 {
+		return false
+	}
+
+	c.discard = fe.Size
+	c.stats.droppedFrames.Add(1)
+	return true
+}
+
+// handleOut 处理可写事件，发送连接发送队列中的数据
+func (en *engine) handleOut(ev event) {
+	c := en.conns.Get(ev.id)
+	if c == nil {
+		return
+	}
+	// 发送失败时会收到错误或关闭事件，在那里处理
+	c.flush()
+}
+
+// closeConn 关闭连接：停止监听该套接字，调用OnClose（reason为ErrConnReset时调用OnError），
+// 从管理器中删除并关闭套接字。可以在任意goroutine中调用，同一连接只执行一次
+func (en *engine) closeConn(c *Conn, reason error) {
+	if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+		return
+	}
+	c.setState(Conn_State_Closed)
+	c.mu.Lock()
+	c.closeReason = reason
+	c.mu.Unlock()
+	c.cancel(reason)
+	c.stopTimers()
+
+	en.poller.unwatch(c)
+	if reason == ErrConnReset {
+		// In TCP, this typically means a RST has been received or sent.
+		en.handler.OnError(c)
+	} else {
+		en.handler.OnClose(c)
+	}
+	en.conns.remove(c)
+	c.closeFd()
+}
+
+// connected 连接已加入监听，调用OnConnect，OnConnect中未进入握手状态（或关闭连接）时进入Active状态
+func (en *engine) connected(c *Conn) {
+	en.handler.OnConnect(c)
+	c.compareAndSwapState(Conn_State_Accepted, Conn_State_Active)
+}
+
+// closeAll 停止检测空闲超时，并以reason关闭该实例的所有连接
+func (en *engine) closeAll(reason error) {
+	en.conns.stopWheel()
+	en.conns.Range(func(c *Conn) bool {
+		en.closeConn(c, reason)
+		return true
+	})
+}
@@ -0,0 +1,234 @@
+package go_conn_manager
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	Stream_Buffer_Size = 64 * 1024 // NetConn中未读取的数据超过该值时暂停从套接字读取，由TCP流量控制限制对方发送
+)
+
+// NetConn 把Conn包装为net.Conn，用于在连接上使用TLS、bufio、encoding/gob、HTTP等基于net.Conn的库。
+// 创建后该连接读到的数据不再经过Codec与OnMessage，而是原样放入NetConn的缓冲区，由Read读取，
+// 套接字仍由多路复用实例读取，不需要每个连接一个goroutine阻塞在套接字上；
+// Write不经过Codec，通过连接的发送队列发送
+type NetConn struct {
+	c *Conn
+
+	mu       sync.Mutex
+	buf      []byte        // 已读到但还未被Read取走的数据
+	eof      bool          // 对方已关闭写端，缓冲区中的数据读完后Read返回io.EOF
+	closed   bool          // 已调用Close
+	readable chan struct{} // 有新数据或者状态变化时通知阻塞的Read
+	writable chan struct{} // 连接恢复可写或者状态变化时通知阻塞的Write
+
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+// NewNetConn 把c包装为net.Conn，同一连接多次调用返回同一实例。
+// 应在OnConnect或OnMessage中调用，之后到达的数据都由NetConn读取
+func NewNetConn(c *Conn) *NetConn {
+	nc := &NetConn{
+		c:             c,
+		readable:      make(chan struct{}, 1),
+		writable:      make(chan struct{}, 1),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+	if !c.stream.CompareAndSwap(nil, nc) {
+		return c.stream.Load()
+	}
+	return nc
+}
+
+// Conn 返回被包装的连接
+func (nc *NetConn) Conn() *Conn {
+	return nc.c
+}
+
+// Read 读取连接上到达的数据，没有数据时阻塞，直到有数据到达、连接关闭或者超过读超时时间。
+// 对方关闭写端或关闭连接时返回io.EOF，超时返回os.ErrDeadlineExceeded
+func (nc *NetConn) Read(b []byte) (int, error) {
+	for {
+		nc.mu.Lock()
+		if nc.closed {
+			nc.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if len(nc.buf) > 0 {
+			n := copy(b, nc.buf)
+			nc.buf = nc.buf[:copy(nc.buf, nc.buf[n:])]
+			full := len(nc.buf) >= Stream_Buffer_Size
+			nc.mu.Unlock()
+
+			if !full {
+				nc.resume()
+			}
+			return n, nil
+		}
+		eof := nc.eof
+		nc.mu.Unlock()
+
+		if eof {
+			return 0, io.EOF
+		}
+		if err := nc.closeErr(); err != nil {
+			return 0, err
+		}
+
+		select {
+		case <-nc.readable:
+		case <-nc.c.ctx.Done():
+		case <-nc.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Write 通过发送队列发送数据。连接不可写（发送队列超过高水位）时阻塞，
+// 直到恢复可写、连接关闭或者超过写超时时间，超时返回os.ErrDeadlineExceeded
+func (nc *NetConn) Write(b []byte) (int, error) {
+	for !nc.c.Writable() {
+		if err := nc.closeErr(); err != nil {
+			return 0, err
+		}
+		select {
+		case <-nc.writable:
+		case <-nc.c.ctx.Done():
+		case <-nc.writeDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	select {
+	case <-nc.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+
+	err := nc.c.SendFrame(b)
+	if err == ErrConnClosed {
+		if cerr := nc.closeErr(); cerr != nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// closeErr 已调用Close时返回net.ErrClosed，连接已关闭时返回对应的错误（对方关闭时为io.EOF），否则返回nil
+func (nc *NetConn) closeErr() error {
+	nc.mu.Lock()
+	closed := nc.closed
+	nc.mu.Unlock()
+	if closed {
+		return net.ErrClosed
+	}
+
+	if nc.c.ctx.Err() == nil {
+		return nil
+	}
+	cause := context.Cause(nc.c.ctx)
+	if cause == ErrPeerClosed {
+		return io.EOF
+	}
+	return cause
+}
+
+// Close 关闭连接，与Conn.Close相同，阻塞的Read与Write返回net.ErrClosed
+func (nc *NetConn) Close() error {
+	nc.mu.Lock()
+	nc.closed = true
+	nc.mu.Unlock()
+	notify(nc.readable)
+	notify(nc.writable)
+
+	nc.c.Close()
+	return nil
+}
+
+// CloseWrite 关闭连接的写端，见Conn.CloseWrite
+func (nc *NetConn) CloseWrite() error {
+	return nc.c.CloseWrite()
+}
+
+func (nc *NetConn) LocalAddr() net.Addr {
+	return nc.c.LocalAddr()
+}
+
+func (nc *NetConn) RemoteAddr() net.Addr {
+	return nc.c.RemoteAddr()
+}
+
+func (nc *NetConn) SetDeadline(t time.Time) error {
+	nc.readDeadline.set(t)
+	nc.writeDeadline.set(t)
+	return nil
+}
+
+func (nc *NetConn) SetReadDeadline(t time.Time) error {
+	nc.readDeadline.set(t)
+	return nil
+}
+
+func (nc *NetConn) SetWriteDeadline(t time.Time) error {
+	nc.writeDeadline.set(t)
+	return nil
+}
+
+// feed 放入从套接字读到的数据，由读取连接的goroutine调用
+func (nc *NetConn) feed(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	nc.mu.Lock()
+	nc.buf = append(nc.buf, data...)
+	nc.mu.Unlock()
+	notify(nc.readable)
+}
+
+// closeRead 对方已关闭写端，缓冲区中的数据读完后Read返回io.EOF
+func (nc *NetConn) closeRead() {
+	nc.mu.Lock()
+	nc.eof = true
+	nc.mu.Unlock()
+	notify(nc.readable)
+}
+
+// full 返回未读取的数据是否已超过Stream_Buffer_Size，超过时暂停从套接字读取
+func (nc *NetConn) full() bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	return len(nc.buf) >= Stream_Buffer_Size
+}
+
+// pause 暂停从套接字读取，返回false时缓冲区已有空间或者已恢复读取，不需要暂停
+func (nc *NetConn) pause() bool {
+	nc.c.pauseRead()
+	// 设置标记前Read可能已取走数据，此时不会再恢复读取，需要再检查一次
+	if nc.full() {
+		return true
+	}
+	return !nc.c.unpauseRead()
+}
+
+// resume 恢复被暂停的读取
+func (nc *NetConn) resume() {
+	nc.c.resumeRead()
+}
+
+// notify 非阻塞地通知等待ch的goroutine
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,54 @@
+package go_conn_manager
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// 对方关闭写端后NetConn仍可以继续发送，io.Copy回显的数据全部到达后才关闭连接
+func TestNetConnEchoHalfClose(t *testing.T) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			closed := make(chan error, 1)
+			h := &testHandler{
+				onConnect: func(c *Conn) {
+					nc := NewNetConn(c)
+					go func() {
+						io.Copy(nc, nc)
+						nc.CloseWrite()
+					}()
+				},
+				onClose: func(c *Conn) { closed <- c.CloseReason() },
+			}
+			addr := startTestServer(t, b.new(), h)
+
+			data := make([]byte, 1<<20)
+			rand.New(rand.NewSource(1)).Read(data)
+			c := dialTest(t, addr)
+			go func() {
+				c.Write(data)
+				c.CloseWrite()
+			}()
+
+			got, err := io.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("收到%d字节，发送了%d字节", len(got), len(data))
+			}
+
+			select {
+			case reason := <-closed:
+				if reason != ErrPeerClosed {
+					t.Fatalf("关闭原因为%v", reason)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("双方都关闭写端后连接未关闭")
+			}
+		})
+	}
+}
@@ -1,128 +1,242 @@
 package go_conn_manager
 
 import (
-	"encoding/binary"
 	"errors"
 	"io"
 	"sync"
+	"sync/atomic"
 	"syscall"
 )
 
+const (
+	Read_Buffer_Size = 4 * 1024 // 连接读缓冲区的初始大小，不足以容纳一个完整数据包时会扩容
+)
+
 var (
-	PackageHeaderLen   int
-	PackageReadMaxLen  int
+	// PackageHeaderLen 最后一次InitPackage设置的头部长度
+	//
+	// Deprecated: 使用Codec
+	PackageHeaderLen int
+	// PackageReadMaxLen 最后一次InitPackage设置的接收数据包的最大长度
+	//
+	// Deprecated: 使用Codec
+	PackageReadMaxLen int
+	// PackageWriteMaxLen 最后一次InitPackage设置的发送数据包的最大长度
+	//
+	// Deprecated: 使用Codec
 	PackageWriteMaxLen int
+
+	// packageCodec Packet与Unpack使用的LengthFieldCodec，由InitPackage设置
+	packageCodec atomic.Pointer[LengthFieldCodec]
 )
 
 type HandleMessage func(*Conn, []byte)
 
-var (
-	readBufferPool  *sync.Pool
-	writeBufferPool *sync.Pool
-)
+var readBufferPool = &sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 0, Read_Buffer_Size)
+	},
+}
 
+// InitPackage 设置Packet与Unpack使用的LengthFieldCodec，参数与NewLengthFieldCodec相同。
+// 不影响server使用的Codec，server.Start也不会调用该函数，使用Packet与Unpack前需要自行调用。
+// Package*变量没有同步，应在启动时调用一次，不能与读取这些变量并发
+//
+// Deprecated: 使用NewLengthFieldCodec创建Codec，通过server.SetCodec设置
 func InitPackage(headerLen, readMaxLen, writeMaxLen int) {
+	packageCodec.Store(NewLengthFieldCodec(headerLen, readMaxLen, writeMaxLen))
 	PackageHeaderLen = headerLen
 	PackageReadMaxLen = readMaxLen
 	PackageWriteMaxLen = writeMaxLen
-
-	readBufferPool = &sync.Pool{
-		New: func() interface{} {
-			return make([]byte, readMaxLen)
-		},
-	}
-	writeBufferPool = &sync.Pool{
-		New: func() interface{} {
-			return make([]byte, writeMaxLen)
-		},
-	}
 }
 
-// 封包，失败返回nil
+// Packet 使用InitPackage设置的LengthFieldCodec封包，失败或者未调用InitPackage时返回nil
+//
+// Deprecated: 使用Codec.Encode
 func Packet(data []byte) []byte {
-	dataLen := len(data)
-	retData := make([]byte, PackageHeaderLen+dataLen)
-	binary.BigEndian.PutUint16(retData[0:PackageHeaderLen], uint16(dataLen))
-	n := copy(retData[PackageHeaderLen:], data)
-	if n != dataLen {
+	lc := packageCodec.Load()
+	if lc == nil {
 		return nil
 	}
-
-	return retData
+	b, err := lc.Encode(data)
+	if err != nil {
+		return nil
+	}
+	return b
 }
 
-// 解包，失败返回nil
+// Unpack 使用InitPackage设置的LengthFieldCodec从data开头解出一个数据包，返回其内容的拷贝，
+// 失败、数据不完整或者未调用InitPackage时返回nil
+//
+// Deprecated: 使用Codec.Decode
 func Unpack(data []byte) []byte {
-	dataLen := binary.BigEndian.Uint16(data[0:PackageHeaderLen])
-	retData := make([]byte, dataLen)
-	n := copy(retData, data[PackageHeaderLen:PackageHeaderLen+int(dataLen)])
-	if n != int(dataLen) {
+	lc := packageCodec.Load()
+	if lc == nil {
+		return nil
+	}
+	b, n, err := lc.Decode(data)
+	if err != nil || n == 0 {
 		return nil
 	}
-	return retData
+	return append([]byte{}, b...)
 }
 
-// 读取、解包并处理
+// UnpackFromFD 读取、解包并处理。
+// 把套接字中的数据读入连接的读缓冲区，直到套接字中没有数据，并处理缓冲区中所有完整的数据包，
+// 不完整的数据留在缓冲区中等待更多数据到达；缓冲区中没有剩余数据时把缓冲区放回池中。
+// 遇到超出最大长度的数据包时返回*FrameTooLargeError，该数据包留在缓冲区开头，
+// 多路复用实例按配置的处理方式关闭连接或者跳过该数据包；Decode返回其他错误时返回*MalformedFrameError。
+// 已包装为NetConn时读到的数据不解包，原样交给NetConn，见NewNetConn。
+// 设置了速率限制时按处理方式暂停读取、丢弃数据包或者返回ErrRateLimited，见RateLimit。
+// 同一连接不能并发调用，传给h的数据只在h返回前有效
 func UnpackFromFD(c *Conn, h HandleMessage) error {
-	readBuffer := readBufferPool.Get()
-	defer readBufferPool.Put(readBuffer)
+	// 暂停读取期间到达的事件不处理，恢复时会重新读取
+	if atomic.LoadInt32(&c.readPaused) != 0 {
+		return nil
+	}
+
+	buf := c.inbound
+	if buf == nil {
+		buf = readBufferPool.Get().([]byte)
+	}
+	defer func() {
+		c.releaseInbound(buf)
+	}()
 
-	var byte = readBuffer.([]byte)
 	fd := c.Fd()
+	lim := c.limiter.Load()
+	drained := false
 	for {
-		n, _, err := syscall.Recvfrom(fd, byte, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
-		if err != nil {
-			// no data is waiting to be received
-			if err == syscall.EAGAIN {
-				return nil
+		// 丢弃被跳过的数据包
+		if c.discard > 0 {
+			n := c.discard
+			if n > len(buf) {
+				n = len(buf)
 			}
-			return err
+			buf = buf[:copy(buf, buf[n:])]
+			c.discard -= n
 		}
-		if n == 0 {
-			return io.EOF
+
+		start := 0
+		for start < len(buf) {
+			// 已包装为NetConn时剩余的数据原样交给NetConn
+			if s := c.stream.Load(); s != nil {
+				s.feed(buf[start:])
+				start = len(buf)
+				break
+			}
+			data, dataLen, err := c.codec.Decode(buf[start:])
+			if err != nil {
+				buf = buf[:copy(buf, buf[start:])]
+				return decodeError(err)
+			}
+			if dataLen == 0 {
+				break
+			}
+			if lim != nil {
+				if wait := lim.reserve(dataLen); wait > 0 {
+					switch lim.action {
+					case Rate_Limit_Drop:
+						start += dataLen
+						c.stats.droppedFrames.Add(1)
+						continue
+					case Rate_Limit_Close:
+						return ErrRateLimited
+					default:
+						// 该数据包留在缓冲区中，等令牌足够后再处理
+						buf = buf[:copy(buf, buf[start:])]
+						c.delayRead(wait)
+						return nil
+					}
+				}
+			}
+			start += dataLen
+			// 连接已开始关闭，不再处理后面的数据包
+			if c.isClosing() {
+				return nil
+			}
+
+			c.stats.framesIn.Add(1)
+			c.clearReadDeadline()
+			h(c, data)
 		}
+		// 把剩余的不完整数据移到缓冲区开头
+		buf = buf[:copy(buf, buf[start:])]
 
-		if n < PackageHeaderLen {
+		// 读到的数据少于缓冲区剩余空间，说明套接字中的数据已读完，
+		// 不需要再调用一次读取来确认EAGAIN，之后有新数据到达时会再次触发事件；
+		// 但对方已关闭写端时不会再有事件，需要继续读到EOF
+		if drained && atomic.LoadInt32(&c.peerHup) == 0 {
 			return nil
 		}
-
-		dataLen := int(binary.BigEndian.Uint16(byte[0:PackageHeaderLen]))
-		if dataLen+PackageHeaderLen > n {
+		// NetConn中未读取的数据过多时暂停读取，由NetConn.Read恢复
+		if s := c.stream.Load(); s != nil && s.full() && s.pause() {
 			return nil
 		}
-		n, _, err = syscall.Recvfrom(fd, byte[0:PackageHeaderLen+dataLen], syscall.MSG_DONTWAIT)
+
+		if len(buf) == cap(buf) {
+			// 缓冲区已满但仍不足一个完整的数据包，扩容
+			nbuf := make([]byte, len(buf), 2*cap(buf))
+			copy(nbuf, buf)
+			buf = nbuf
+		}
+
+		space := cap(buf) - len(buf)
+		n, _, err := syscall.Recvfrom(fd, buf[len(buf):cap(buf)], syscall.MSG_DONTWAIT)
 		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			// no data is waiting to be received
+			if err == syscall.EAGAIN {
+				return nil
+			}
 			return err
 		}
-
-		c.UpdateLastTime()
-		h(c, byte[PackageHeaderLen:PackageHeaderLen+dataLen])
+		if n == 0 {
+			if s := c.stream.Load(); s != nil {
+				s.closeRead()
+			}
+			return io.EOF
+		}
+		buf = buf[:len(buf)+n]
+		c.stats.read(n)
+		drained = n < space
 	}
 }
 
-// 封包并发送
-func PacketToPeer(c *Conn, data []byte) error {
-	dataLen := len(data)
-	if dataLen > PackageWriteMaxLen {
-		return errors.New("数据超出最大长度限制")
+// decodeError 把Codec返回的错误转换为*FrameTooLargeError或*MalformedFrameError，
+// 以便与读取套接字的错误区分
+func decodeError(err error) error {
+	var fe *FrameTooLargeError
+	var me *MalformedFrameError
+	if errors.As(err, &fe) || errors.As(err, &me) {
+		return err
 	}
+	return &MalformedFrameError{Err: err}
+}
 
-	writeBuffer := writeBufferPool.Get()
-	defer writeBufferPool.Put(writeBuffer)
-
-	var buffer = writeBuffer.([]byte)
-	// 写入数据长度
-	binary.BigEndian.PutUint16(buffer[0:PackageHeaderLen], uint16(dataLen))
-	// 把数据拷贝入发送缓冲区
-	n := copy(buffer[PackageHeaderLen:], data)
-	if n != dataLen {
-		return errors.New("数据拷贝发生错误")
-	}
+// PacketToPeer 封包并发送，与c.Send相同
+func PacketToPeer(c *Conn, data []byte) error {
+	return c.Send(data)
+}
 
-	_, err := syscall.Write(c.Fd(), buffer[:PackageHeaderLen+dataLen])
-	if err != nil {
-		return err
+// writeFd 向非阻塞的套接字写数据，直到写完或者套接字缓冲区已满，返回写入的字节数
+func writeFd(fd int, b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := syscall.Write(fd, b[written:])
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				return written, nil
+			}
+			return written, err
+		}
+		written += n
 	}
 
-	return nil
+	return written, nil
 }
@@ -0,0 +1,83 @@
+package go_conn_manager
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketUnpack(t *testing.T) {
+	InitPackage(2, 512, 512)
+	if PackageHeaderLen != 2 || PackageReadMaxLen != 512 || PackageWriteMaxLen != 512 {
+		t.Fatalf("InitPackage未设置参数: %d %d %d", PackageHeaderLen, PackageReadMaxLen, PackageWriteMaxLen)
+	}
+
+	b := Packet([]byte("abc"))
+	if want := []byte{0, 3, 'a', 'b', 'c'}; !bytes.Equal(b, want) {
+		t.Fatalf("Packet返回%v，应为%v", b, want)
+	}
+	if got := Unpack(append(b, 0, 1)); string(got) != "abc" {
+		t.Fatalf("Unpack返回%q", got)
+	}
+	if got := Unpack(b[:3]); got != nil {
+		t.Fatalf("数据不完整时Unpack返回%q", got)
+	}
+	if got := Packet(make([]byte, 511)); got != nil {
+		t.Fatal("超出最大长度时Packet未返回nil")
+	}
+}
+
+// freePort 返回一个当前未使用的端口
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// 并发启动多个server时不修改Packet与Unpack使用的全局设置
+func TestStartConcurrently(t *testing.T) {
+	InitPackage(2, 512, 512)
+
+	type started struct {
+		s         *server
+		port      int
+		connected chan struct{}
+	}
+	var servers []started
+	for i, b := range testBackends {
+		st := started{s: NewServer(b.new()), port: freePort(t), connected: make(chan struct{}, 1)}
+		h := &testHandler{onConnect: func(*Conn) { st.connected <- struct{}{} }}
+		go st.s.Start("127.0.0.1", st.port, 4*(i+1), 1024, 1024, h)
+		servers = append(servers, st)
+	}
+
+	for _, st := range servers {
+		// 连接成功说明已完成Init，之后可以Stop
+		var c net.Conn
+		var err error
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if c, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", st.port)); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-st.connected
+		c.Close()
+		defer st.s.Stop()
+	}
+
+	if PackageHeaderLen != 2 || PackageReadMaxLen != 512 || PackageWriteMaxLen != 512 {
+		t.Fatalf("Start修改了InitPackage的参数: %d %d %d", PackageHeaderLen, PackageReadMaxLen, PackageWriteMaxLen)
+	}
+	if b := Packet([]byte("a")); len(b) != 3 {
+		t.Fatalf("Packet使用了Start的参数: %v", b)
+	}
+}
@@ -2,93 +2,72 @@ package go_conn_manager
 
 import (
 	"golang.org/x/sys/unix"
-	"io"
-	"strconv"
-	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	Poll_Event_Listen = unix.POLLIN | unix.POLLPRI
 	Poll_Event_Read   = unix.POLLIN | unix.POLLPRI | unix.POLLHUP | unix.POLLRDHUP | unix.POLLERR
+	Poll_Event_Write  = Poll_Event_Read | unix.POLLOUT
+
+	pollEventIn = unix.POLLIN | unix.POLLPRI | unix.POLLRDHUP // 暂停读取时不监听的事件
 )
 
 type Poll struct {
+	engine
 	mu       sync.Mutex
 	listenFd int
-	handler  Handler
-	conns    *ConnManager
 	fds      map[int32]*unix.PollFd
-	revents  chan event
-	ticker   *time.Ticker
-	interval int64
+	wakeFds  [2]int // 用于唤醒阻塞在Poll方法上的WaitEvent，以便修改后的监听事件生效，WaitEvent返回前关闭，之后为-1
 	stop     chan struct{}
 }
 
 // NewPoll 创建Poll实例，interval指定检测长时间未使用的连接并关闭其
 func NewPoll(interval time.Duration) *Poll {
-	return &Poll{
-		conns:    NewConnManager(interval),
-		fds:      make(map[int32]*unix.PollFd),
-		revents:  make(chan event),
-		ticker:   time.NewTicker(interval),
-		interval: int64(interval.Seconds()),
-		stop:     make(chan struct{}),
+	p := &Poll{
+		engine: engine{
+			conns:   NewConnManager(interval),
+			revents: make(chan event),
+		},
+		fds:     make(map[int32]*unix.PollFd),
+		wakeFds: [2]int{-1, -1},
+		stop:    make(chan struct{}),
 	}
-}
-
-func (p *Poll) SetHandler(h Handler) {
-	p.handler = h
+	p.poller = p
+	return p
 }
 
 func (p *Poll) Init(ipAddr string, port int) error {
-	listenFd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	listenFd, err := p.listen(ipAddr, port)
 	if err != nil {
 		return err
 	}
 	p.listenFd = listenFd
 
-	addr := [4]byte{}
-	if ipAddr != "" {
-		s := strings.Split(ipAddr, ".")
-		for i := range s {
-			v, err := strconv.Atoi(s[i])
-			if err != nil {
-				return err
-			}
-			addr[i] = byte(v)
-		}
-	}
-	err = syscall.Bind(p.listenFd, &syscall.SockaddrInet4{
-		Port: port,
-		Addr: addr,
-	})
-	if err != nil {
-		return err
+	p.fds[int32(listenFd)] = &unix.PollFd{
+		Fd:     int32(listenFd),
+		Events: Poll_Event_Listen,
 	}
 
-	err = syscall.Listen(p.listenFd, Listen_Queue_Size)
+	err = unix.Pipe2(p.wakeFds[:], unix.O_NONBLOCK|unix.O_CLOEXEC)
 	if err != nil {
 		return err
 	}
-
-	p.fds[int32(listenFd)] = &unix.PollFd{
-		Fd:     int32(listenFd),
-		Events: Poll_Event_Listen,
+	p.fds[int32(p.wakeFds[0])] = &unix.PollFd{
+		Fd:     int32(p.wakeFds[0]),
+		Events: unix.POLLIN,
 	}
 
-	go p.checkTimeout()
-
 	return nil
 }
 
 func (p *Poll) WaitEvent() {
 	for {
+		p.mu.Lock()
 		fds := make([]unix.PollFd, len(p.fds))
 		i := 0
-		p.mu.Lock()
 		for _, val := range p.fds {
 			fds[i] = *val
 			i++
@@ -97,7 +76,9 @@ func (p *Poll) WaitEvent() {
 
 		select {
 		case <-p.stop:
-			break
+			p.closeWakeup()
+			close(p.revents)
+			return
 		default:
 			n, err := unix.Poll(fds, -1)
 			if err != nil {
@@ -111,12 +92,41 @@ func (p *Poll) WaitEvent() {
 
 func (p *Poll) handleFds(fds []unix.PollFd, n int) {
 	fdCh := make(chan event, n)
-	for i := 0; i < n; i++ {
+	// n为有事件发生的套接字数量，需要遍历所有套接字找出这些套接字
+	for i := 0; i < len(fds) && n > 0; i++ {
 		if fds[i].Revents == 0 {
 			continue
 		}
+		n--
 
-		if (fds[i].Revents & unix.POLLIN) > 0 {
+		if fds[i].Fd == int32(p.wakeFds[0]) {
+			p.drainWakeup()
+			continue
+		}
+		// 连接的接受与关闭都在这个goroutine中进行，这里根据fd找到的连接就是产生该事件的连接，
+		// 事件中带上连接ID，之后处理时fd即使已被复用也不会当作新连接的事件
+		var id uint64
+		if fds[i].Fd != int32(p.listenFd) {
+			c := p.conns.GetConnByFd(int(fds[i].Fd))
+			if c == nil {
+				continue
+			}
+			id = c.id
+			// 暂停读取期间无法屏蔽的POLLHUP、POLLERR每次Poll都会立即返回，见exclude
+			if (fds[i].Revents&(unix.POLLHUP|unix.POLLERR)) > 0 && p.exclude(c) {
+				continue
+			}
+		}
+		if (fds[i].Revents & unix.POLLOUT) > 0 {
+			// 写操作不会阻塞，直接在这里发送，以便下一次Poll前停止监听可写事件
+			p.handleOut(event{
+				id:    id,
+				event: Event_Type_Out,
+			})
+		}
+		// 对方关闭连接（POLLRDHUP、POLLHUP）时也先读取，把剩余的数据包处理完后再关闭，
+		// 读到EOF时关闭连接，见handleIn
+		if (fds[i].Revents & (unix.POLLIN | unix.POLLRDHUP | unix.POLLHUP)) > 0 {
 			if fds[i].Fd == int32(p.listenFd) {
 				fdCh <- event{
 					fd:    fds[i].Fd,
@@ -124,21 +134,16 @@ func (p *Poll) handleFds(fds []unix.PollFd, n int) {
 				}
 			} else {
 				p.revents <- event{
-					fd:    fds[i].Fd,
+					id:    id,
 					event: Event_Type_In,
+					hup:   (fds[i].Revents & (unix.POLLRDHUP | unix.POLLHUP)) > 0,
 				}
 			}
 		} else if (fds[i].Revents & unix.POLLERR) > 0 {
 			p.revents <- event{
-				fd:    fds[i].Fd,
+				id:    id,
 				event: Event_Type_Error,
 			}
-		} else if (fds[i].Revents&unix.POLLRDHUP) > 0 || (fds[i].Revents&unix.POLLHUP) > 0 {
-			// POLLHUP: FIN has been received and sent.
-			fdCh <- event{
-				fd:    fds[i].Fd,
-				event: Event_Type_Close,
-			}
 		}
 	}
 	close(fdCh)
@@ -146,44 +151,141 @@ func (p *Poll) handleFds(fds []unix.PollFd, n int) {
 }
 
 func (p *Poll) AddRead(nfd int, c *Conn) error {
+	p.mu.Lock()
 	p.fds[int32(nfd)] = &unix.PollFd{
 		Fd:     int32(nfd),
 		Events: Poll_Event_Read,
 	}
-	p.conns.AddConn(nfd, c)
-	p.handler.OnConnect(c)
+	p.mu.Unlock()
+	p.conns.Add(c)
+	p.connected(c)
+
+	return nil
+}
+
+// watchWrite 开始或停止监听套接字的可写事件
+func (p *Poll) watchWrite(c *Conn, on bool) error {
+	p.mu.Lock()
+	if pfd, ok := p.fds[int32(c.fd)]; ok {
+		if on {
+			pfd.Events |= unix.POLLOUT
+		} else {
+			pfd.Events &^= unix.POLLOUT
+		}
+	}
+	p.mu.Unlock()
 
+	// 停止监听不需要唤醒，多出的一次可写事件不影响
+	if on {
+		p.wakeup()
+	}
 	return nil
 }
 
+// watchRead 开始或停止监听套接字的可读事件。Poll为水平触发，暂停读取时套接字中还有数据，
+// 不停止监听的话每次Poll都会立即返回可读事件
+func (p *Poll) watchRead(c *Conn, on bool) error {
+	p.mu.Lock()
+	if pfd, ok := p.fds[int32(c.fd)]; ok {
+		if on {
+			pfd.Events |= pollEventIn
+			// 恢复被exclude移出的套接字
+			pfd.Fd = int32(c.fd)
+		} else {
+			pfd.Events &^= pollEventIn
+		}
+	}
+	p.mu.Unlock()
+
+	// 停止监听时WaitEvent的下一次Poll即不再包括可读事件，不需要唤醒
+	if on {
+		p.wakeup()
+	}
+	return nil
+}
+
+// exclude 暂停读取的连接产生POLLHUP或POLLERR时，把套接字移出Poll（fd为负数时Poll忽略该项），
+// 返回是否已移出。这两个事件无法通过events屏蔽，而暂停期间不读取套接字，事件不会消失；
+// 此时对方已重置连接或双方都已关闭写端，也不需要再监听可写事件。恢复读取时由watchRead移回，
+// 读取时得到错误或EOF后关闭连接
+func (p *Poll) exclude(c *Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 加锁后再检查，unpauseRead先清除标记再调用watchRead，不会在恢复后被移出
+	if atomic.LoadInt32(&c.readPaused) == 0 {
+		return false
+	}
+	if pfd, ok := p.fds[int32(c.fd)]; ok {
+		pfd.Fd = -1
+	}
+	return true
+}
+
+// wakeup 唤醒阻塞在Poll方法上的WaitEvent
+func (p *Poll) wakeup() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 已关闭时fd可能已被复用，不能再写
+	if p.wakeFds[1] >= 0 {
+		unix.Write(p.wakeFds[1], []byte{0})
+	}
+}
+
+// closeWakeup 关闭唤醒用的管道，由WaitEvent在最后一次Poll返回后调用
+func (p *Poll) closeWakeup() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.wakeFds[0] >= 0 {
+		delete(p.fds, int32(p.wakeFds[0]))
+		unix.Close(p.wakeFds[0])
+		unix.Close(p.wakeFds[1])
+		p.wakeFds = [2]int{-1, -1}
+	}
+}
+
+func (p *Poll) drainWakeup() {
+	buf := make([]byte, 64)
+	for {
+		n, err := unix.Read(p.wakeFds[0], buf)
+		if n <= 0 || err != nil {
+			return
+		}
+	}
+}
+
+// unwatch 从监听中删除套接字
+func (p *Poll) unwatch(c *Conn) error {
+	// 这里的删除需要加锁是因为关闭连接与WaitEvent是并发运行的
+	p.mu.Lock()
+	delete(p.fds, int32(c.fd))
+	p.mu.Unlock()
+
+	// 阻塞在Poll方法上时内核持有该套接字的引用，关闭fd后套接字并不会真正关闭（不会发送FIN），
+	// 需要唤醒WaitEvent，使下一次Poll不再包括该套接字
+	p.wakeup()
+	return nil
+}
+
+// Del 从监听中删除套接字，删除conn，调用OnClose回调函数并关闭套接字，与Conn.Close相同
 func (p *Poll) Del(nfd int) error {
-	delete(p.fds, int32(nfd))
-	p.handler.OnClose(p.conns.GetConn(nfd))
-	p.conns.DelConn(nfd)
+	c := p.conns.GetConnByFd(nfd)
+	if c == nil {
+		return ErrConnClosed
+	}
+	p.closeConn(c, ErrKicked)
 
 	return nil
 }
 
-// handleConnect 处理新增连接与连接关闭
+// handleConnect 处理新增连接
 func (p *Poll) handleConnect(fdCh <-chan event) {
 	// 该方法不需要加锁是因为这是WaitEvent的同步操作
 	for ev := range fdCh {
 		if ev.event == Event_Type_Connect {
-			nfd, sa, err := syscall.Accept(int(ev.fd))
-			if err != nil {
-				continue
-			}
-			c := &Conn{
-				fd:       nfd,
-				SockAddr: sa,
-				lastTime: time.Now().Unix(),
-			}
-			err = p.AddRead(nfd, c)
-			if err != nil {
-				continue
-			}
-		} else if ev.event == Event_Type_Close {
-			p.Del(int(ev.fd))
+			p.acceptAll(int(ev.fd), p.AddRead)
 		}
 	}
 }
@@ -191,63 +293,21 @@ func (p *Poll) handleConnect(fdCh <-chan event) {
 func (p *Poll) HandleEvent() error {
 	for ev := range p.revents {
 		if ev.event == Event_Type_In {
-			go func(ev event) {
-				err := UnpackFromFD(p.conns.GetConn(int(ev.fd)), p.handler.OnMessage)
-				if err != nil && err == io.EOF {
-					// 读取中检测到对方关闭了套接字
-					p.revents <- event{
-						fd:    ev.fd,
-						event: Event_Type_Close,
-					}
-				}
-				p.conns.GetConn(int(ev.fd)).UpdateLastTime()
-			}(ev)
+			p.handleIn(ev)
 		} else if ev.event == Event_Type_Error {
 			// In TCP, this typically means a RST has been received or sent.
-			p.handler.OnError(p.conns.GetConn(int(ev.fd)))
-			p.conns.DelConn(int(ev.fd))
-			// 这里的删除需要加锁是因为HandleEvent与WaitEvent是并发运行的
-			p.mu.Lock()
-			delete(p.fds, ev.fd)
-			p.mu.Unlock()
-		} else if ev.event == Event_Type_Close {
-			// 这里的删除需要加锁是因为HandleEvent与WaitEvent是并发运行的
-			p.mu.Lock()
-			p.Del(int(ev.fd))
-			p.mu.Unlock()
+			if c := p.conns.Get(ev.id); c != nil {
+				p.closeConn(c, ErrConnReset)
+			}
 		}
 	}
 	return nil
 }
 
+// Stop 停止服务，以ErrServerShutdown关闭所有连接，WaitEvent与HandleEvent随之返回
 func (p *Poll) Stop() {
 	close(p.stop)
-}
-
-// checkTimeout 把在指定时间内一次通信都没有的连接关闭，
-// 因为也许对方由于某些原因已经不使用该连接
-func (p *Poll) checkTimeout() {
-	for {
-		select {
-		case <-p.ticker.C:
-			p.check()
-		case <-p.stop:
-			break
-		}
-	}
-}
-
-func (p *Poll) check() {
-	conns := p.conns.Conns()
-	for k, v := range conns {
-		interval := time.Now().Unix() - v.LastTime()
-		if interval < p.interval {
-			continue
-		}
-
-		p.revents <- event{
-			fd:    int32(k),
-			event: Event_Type_Close,
-		}
-	}
+	// 没有连接时不会因为unwatch唤醒，需要主动唤醒
+	p.wakeup()
+	p.closeAll(ErrServerShutdown)
 }
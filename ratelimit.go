@@ -0,0 +1,150 @@
+package go_conn_manager
+
+import (
+	"errors"
+	"time"
+)
+
+// RateLimitAction 连接接收数据包的速率超出限制时的处理方式
+type RateLimitAction int8
+
+const (
+	Rate_Limit_Delay RateLimitAction = iota // 暂停读取，令牌足够后再继续，期间由TCP流量控制限制对方发送
+	Rate_Limit_Drop                         // 丢弃超出限制的数据包，计入DroppedFrames
+	Rate_Limit_Close                        // 以ErrRateLimited关闭连接
+)
+
+// ErrRateLimited 处理方式为Rate_Limit_Close时，连接因超出速率限制被关闭的原因
+var ErrRateLimited = errors.New("连接超出速率限制")
+
+// RateLimit 连接接收数据包的速率限制（令牌桶），在解包后、调用OnMessage前检查。
+// 包装为NetConn后读到的数据不解包，不受限制
+type RateLimit struct {
+	FramesPerSecond float64 // 每秒的数据包数，为0时不限制
+	FrameBurst      int     // 可以连续接收的数据包数（令牌桶的容量），为0时等于FramesPerSecond（至少为1）
+	BytesPerSecond  float64 // 每秒的字节数（按封包后的长度计算），为0时不限制
+	ByteBurst       int     // 可以连续接收的字节数，为0时等于BytesPerSecond；超出该值的数据包在令牌桶满时放行
+	Action          RateLimitAction
+}
+
+// SetRateLimit 设置新连接的速率限制，需要在Init之前调用，默认不限制。
+// 单个连接可以通过Conn.SetRateLimit修改
+func (en *engine) SetRateLimit(l RateLimit) {
+	en.rateLimit = &l
+}
+
+// SetRateLimit 设置该连接的速率限制，代替多路复用实例的设置，FramesPerSecond与BytesPerSecond都为0时不限制。
+// 令牌桶从满的状态开始
+func (c *Conn) SetRateLimit(l RateLimit) {
+	c.limiter.Store(newRateLimiter(l))
+}
+
+// delayRead 暂停读取，wait后恢复
+func (c *Conn) delayRead(wait time.Duration) {
+	c.pauseRead()
+
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	stopTimer(&c.timers.rateTimer)
+	// 关闭连接时先标记closing再停止定时器，加锁后检查不会遗漏
+	if !c.isClosing() {
+		c.timers.rateTimer = time.AfterFunc(wait, c.resumeRead)
+	}
+}
+
+// rateLimiter 连接的速率限制，令牌桶只在读取该连接的goroutine中使用，不需要加锁
+type rateLimiter struct {
+	action RateLimitAction
+	frames tokenBucket
+	bytes  tokenBucket
+}
+
+// newRateLimiter 返回l对应的rateLimiter，不限制时返回nil
+func newRateLimiter(l RateLimit) *rateLimiter {
+	if l.FramesPerSecond <= 0 && l.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	return &rateLimiter{
+		action: l.Action,
+		frames: newTokenBucket(l.FramesPerSecond, l.FrameBurst, now),
+		bytes:  newTokenBucket(l.BytesPerSecond, l.ByteBurst, now),
+	}
+}
+
+// reserve 接收一个size字节的数据包，返回还需要等待的时间，为0时已取走令牌
+func (l *rateLimiter) reserve(size int) time.Duration {
+	now := time.Now().UnixNano()
+	l.frames.refill(now)
+	l.bytes.refill(now)
+
+	wait := l.frames.wait(1)
+	if w := l.bytes.wait(float64(size)); w > wait {
+		wait = w
+	}
+	if wait == 0 {
+		l.frames.take(1)
+		l.bytes.take(float64(size))
+	}
+	return wait
+}
+
+// tokenBucket 令牌桶，rate为0时不限制
+type tokenBucket struct {
+	rate   float64 // 每秒放入的令牌数
+	burst  float64 // 容量
+	tokens float64
+	last   int64 // 最后一次放入令牌的时间（UnixNano）
+}
+
+func newTokenBucket(rate float64, burst int, now int64) tokenBucket {
+	if rate <= 0 {
+		return tokenBucket{}
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = rate
+	}
+	if b < 1 {
+		b = 1
+	}
+	return tokenBucket{rate: rate, burst: b, tokens: b, last: now}
+}
+
+// refill 按经过的时间放入令牌
+func (b *tokenBucket) refill(now int64) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens += float64(now-b.last) / float64(time.Second) * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// wait 返回令牌足够取走n个还需要等待的时间。n超过容量时等到令牌桶满即可，取走后令牌数为负
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	if n > b.burst {
+		n = b.burst
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	w := time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+	if w <= 0 {
+		w = 1
+	}
+	return w
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
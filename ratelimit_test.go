@@ -0,0 +1,129 @@
+package go_conn_manager
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   int
+		took    float64       // 创建后取走的令牌数
+		elapsed time.Duration // 取走后经过的时间
+		n       float64
+		want    time.Duration
+	}{
+		{"满", 10, 5, 0, 0, 1, 0},
+		{"取完容量", 10, 5, 0, 0, 5, 0},
+		{"空", 10, 5, 5, 0, 1, 100 * time.Millisecond},
+		{"剩余半个", 10, 5, 4.5, 0, 1, 50 * time.Millisecond},
+		{"放入令牌后足够", 10, 5, 5, 100 * time.Millisecond, 1, 0},
+		{"放入部分令牌", 10, 5, 5, 50 * time.Millisecond, 1, 50 * time.Millisecond},
+		{"放入令牌不超过容量", 10, 5, 5, time.Hour, 6, 0},
+		{"容量默认等于速率", 10, 0, 0, 0, 10, 0},
+		{"容量至少为1", 0.5, 0, 0, 0, 1, 0},
+		{"超出容量时等到满", 10, 5, 5, 0, 100, 500 * time.Millisecond},
+		{"超出容量时满即可", 10, 5, 0, 0, 100, 0},
+		{"超出容量后令牌数为负", 10, 5, 100, 0, 1, 9600 * time.Millisecond},
+		{"等待时间至少为1ns", 1e12, 1, 1, 0, 1, time.Nanosecond},
+		{"不限制", 0, 0, 100, 0, 1e9, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.rate, tt.burst, 0)
+			b.take(tt.took)
+			b.refill(int64(tt.elapsed))
+			if got := b.wait(tt.n); got != tt.want {
+				t.Fatalf("wait(%v)返回%v，应为%v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	if newRateLimiter(RateLimit{}) != nil {
+		t.Fatal("不限制时应返回nil")
+	}
+
+	// 数据包数与字节数分别限制，取等待时间较长的；等待时不取走令牌
+	l := newRateLimiter(RateLimit{FramesPerSecond: 1000, FrameBurst: 2, BytesPerSecond: 10, ByteBurst: 100})
+	if w := l.reserve(60); w != 0 {
+		t.Fatalf("第1个数据包等待%v", w)
+	}
+	if w := l.reserve(60); w < 1900*time.Millisecond {
+		t.Fatalf("字节数超出限制时等待%v", w)
+	}
+	if w := l.reserve(40); w != 0 {
+		t.Fatalf("等待后未取走令牌时第3个数据包等待%v", w)
+	}
+	if w := l.reserve(0); w < 900*time.Microsecond {
+		t.Fatalf("数据包数超出限制时等待%v", w)
+	}
+
+	// 超出ByteBurst的数据包在令牌桶满时放行
+	l = newRateLimiter(RateLimit{BytesPerSecond: 10, ByteBurst: 100})
+	if w := l.reserve(1000); w != 0 {
+		t.Fatalf("令牌桶满时大数据包等待%v", w)
+	}
+	if w := l.reserve(1); w < 90*time.Second {
+		t.Fatalf("大数据包之后等待%v", w)
+	}
+}
+
+// cpuTime 返回进程已使用的CPU时间
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// 暂停读取期间对方重置连接时，Poll不能因为无法屏蔽的POLLHUP、POLLERR空转
+func TestPollPausedReset(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(m multiplexing, h *testHandler)
+		send  []byte
+		// 之后会自动恢复读取，读到错误后以ErrConnReset关闭；NetConn要等应用读取
+		resumes bool
+	}{
+		{"超出速率限制", func(m multiplexing, h *testHandler) {
+			engineOf(m).SetRateLimit(RateLimit{FramesPerSecond: 1, FrameBurst: 1, Action: Rate_Limit_Delay})
+		}, []byte{0, 0, 0, 1, 'a', 0, 0, 0, 1, 'b'}, true},
+		{"NetConn缓冲区已满", func(m multiplexing, h *testHandler) {
+			h.onConnect = func(c *Conn) { NewNetConn(c) }
+		}, make([]byte, 4*Stream_Buffer_Size), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewPoll(time.Minute)
+			reset := make(chan struct{})
+			h := &testHandler{onError: func(*Conn) { close(reset) }}
+			tt.setup(m, h)
+			addr := startTestServer(t, m, h)
+
+			c := dialTest(t, addr)
+			c.Write(tt.send)
+			// 等连接暂停读取后重置
+			time.Sleep(100 * time.Millisecond)
+			c.SetLinger(0)
+			c.Close()
+			time.Sleep(50 * time.Millisecond)
+
+			start := cpuTime()
+			time.Sleep(500 * time.Millisecond)
+			if used := cpuTime() - start; used > 150*time.Millisecond {
+				t.Fatalf("暂停读取期间使用了%v的CPU时间", used)
+			}
+			if tt.resumes {
+				select {
+				case <-reset:
+				case <-time.After(2 * time.Second):
+					t.Fatal("恢复读取后连接未关闭")
+				}
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	manager "github.com/SAIKAII/go-conn-manager"
 	"github.com/SAIKAII/go-conn-manager/sample/util"
 	"log"
 	"net"
@@ -10,7 +9,6 @@ import (
 )
 
 func main() {
-	manager.InitPackage(2, 512, 512)
 	conn, err := net.Dial("tcp", "localhost:8081")
 	if err != nil {
 		panic(err)
@@ -1,7 +1,6 @@
 package main
 
 import (
-	manager "github.com/SAIKAII/go-conn-manager"
 	"github.com/SAIKAII/go-conn-manager/sample/util"
 	"log"
 	"net"
@@ -16,7 +15,6 @@ const (
 
 // 这个主要测试多个连接的通信运作是否正常
 func main() {
-	manager.InitPackage(2, 512, 512)
 	cChan := make([]*util.Codec, Connection_Nums)
 	for i := 0; i < Connection_Nums; i++ {
 		conn, err := net.Dial("tcp", "localhost:8081")
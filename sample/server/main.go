@@ -17,7 +17,7 @@ func (*handler) OnConnect(c *manager.Conn) {
 
 func (*handler) OnMessage(c *manager.Conn, data []byte) {
 	log.Println("OnMessage, FD:", c.Fd(), "data:", string(data))
-	err := manager.PacketToPeer(c, data)
+	err := c.Send(data)
 	if err != nil {
 		log.Println(err)
 	}
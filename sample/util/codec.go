@@ -9,6 +9,7 @@ import (
 
 type Codec struct {
 	conn      *net.TCPConn
+	codec     packet.Codec
 	buffer    []byte
 	bufferEnd int
 	closed    bool
@@ -17,6 +18,7 @@ type Codec struct {
 func NewCodec(c net.Conn) *Codec {
 	return &Codec{
 		conn:   c.(*net.TCPConn),
+		codec:  packet.NewLengthFieldCodec(2, 512, 512),
 		buffer: make([]byte, 65535),
 	}
 }
@@ -47,14 +49,19 @@ func (c *Codec) Decode() ([]byte, int, error) {
 	if c.bufferEnd == 0 {
 		return nil, 0, errors.New("缓冲区无数据")
 	}
-	b := packet.Unpack(c.buffer)
-	if b == nil {
+	data, n, err := c.codec.Decode(c.buffer[:c.bufferEnd])
+	if err != nil {
+		return nil, 0, err
+	}
+	if data == nil {
 		return nil, 0, errors.New("解包失败")
 	}
 
-	bLen := len(b)
-	copy(c.buffer, c.buffer[packet.PackageHeaderLen+bLen:c.bufferEnd])
-	c.bufferEnd -= packet.PackageHeaderLen + bLen
+	// data引用的是缓冲区，需要在移动缓冲区数据之前拷贝出来
+	b := make([]byte, len(data))
+	bLen := copy(b, data)
+	copy(c.buffer, c.buffer[n:c.bufferEnd])
+	c.bufferEnd -= n
 
 	return b, bLen, nil
 }
@@ -71,7 +78,10 @@ func (c *Codec) Write(data []byte) error {
 }
 
 func (c *Codec) Encode(data []byte) []byte {
-	b := packet.Packet(data)
+	b, err := c.codec.Encode(data)
+	if err != nil {
+		return nil
+	}
 	return b
 }
 
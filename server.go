@@ -8,8 +8,16 @@ func NewServer(m multiplexing) *server {
 	return &server{multi: m}
 }
 
+// SetCodec 设置该server使用的编解码器，未设置时Start使用默认的LengthFieldCodec
+func (s *server) SetCodec(c Codec) {
+	s.multi.SetCodec(c)
+}
+
+// Start 启动服务，headerLen、readMaxLen与writeMaxLen用于创建默认的LengthFieldCodec，已设置Codec时忽略
 func (s *server) Start(ipAddr string, port, headerLen, readMaxLen, writeMaxLen int, h Handler) {
-	InitPackage(headerLen, readMaxLen, writeMaxLen)
+	if s.multi.Codec() == nil {
+		s.multi.SetCodec(NewLengthFieldCodec(headerLen, readMaxLen, writeMaxLen))
+	}
 	s.multi.SetHandler(h)
 	err := s.multi.Init(ipAddr, port)
 	if err != nil {
@@ -0,0 +1,177 @@
+package go_conn_manager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// testBackends 测试中使用的多路复用实例，每个测试创建新的实例
+var testBackends = []struct {
+	name string
+	new  func() multiplexing
+}{
+	{"epoll", func() multiplexing { return NewEpoll(time.Minute) }},
+	{"poll", func() multiplexing { return NewPoll(time.Minute) }},
+}
+
+// startTestServer 在随机端口上启动m，返回监听地址，测试结束时停止。
+// 未设置Codec时使用4字节长度字段、最大2MB的LengthFieldCodec
+func startTestServer(t *testing.T, m multiplexing, h Handler) string {
+	t.Helper()
+	if m.Codec() == nil {
+		m.SetCodec(NewLengthFieldCodec(4, 2<<20, 2<<20))
+	}
+	m.SetHandler(h)
+	if err := m.Init("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	sa, err := syscall.Getsockname(listenFdOf(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go m.WaitEvent()
+	go m.HandleEvent()
+	t.Cleanup(m.Stop)
+	return fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+}
+
+// engineOf 返回m的engine，用于设置Init之前的配置
+func engineOf(m multiplexing) *engine {
+	switch v := m.(type) {
+	case *Epoll:
+		return &v.engine
+	case *Poll:
+		return &v.engine
+	}
+	panic("未知的多路复用实例")
+}
+
+func listenFdOf(m multiplexing) int {
+	switch v := m.(type) {
+	case *Epoll:
+		return v.listenFd
+	case *Poll:
+		return v.listenFd
+	}
+	panic("未知的多路复用实例")
+}
+
+// dialTest 连接addr，读写超时为5秒
+func dialTest(t *testing.T, addr string) *net.TCPConn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c.(*net.TCPConn)
+}
+
+// writeTestFrame 按startTestServer默认的Codec封包后发送
+func writeTestFrame(t *testing.T, c net.Conn, msg []byte) {
+	t.Helper()
+	b := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(b, uint32(len(msg)))
+	copy(b[4:], msg)
+	if _, err := c.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestFrame 读取一个按startTestServer默认的Codec封包的数据包
+func readTestFrame(t *testing.T, c net.Conn) []byte {
+	t.Helper()
+	h := make([]byte, 4)
+	if _, err := io.ReadFull(c, h); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, binary.BigEndian.Uint32(h))
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testHandler 未实现的回调不做处理，实现了的回调由测试设置
+type testHandler struct {
+	onConnect func(*Conn)
+	onMessage func(*Conn, []byte)
+	onClose   func(*Conn)
+	onError   func(*Conn)
+}
+
+func (h *testHandler) OnConnect(c *Conn) {
+	if h.onConnect != nil {
+		h.onConnect(c)
+	}
+}
+
+func (h *testHandler) OnMessage(c *Conn, data []byte) {
+	if h.onMessage != nil {
+		h.onMessage(c, data)
+	}
+}
+
+func (h *testHandler) OnClose(c *Conn) error {
+	if h.onClose != nil {
+		h.onClose(c)
+	}
+	return nil
+}
+
+func (h *testHandler) OnError(c *Conn) {
+	if h.onError != nil {
+		h.onError(c)
+	}
+}
+
+// 没有连接时Stop也能唤醒WaitEvent，使HandleEvent（以及Start）返回
+func TestStopWithoutConns(t *testing.T) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			m := b.new()
+			m.SetCodec(NewLengthFieldCodec(2, 512, 512))
+			m.SetHandler(&testHandler{})
+			if err := m.Init("127.0.0.1", 0); err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan struct{})
+			go m.WaitEvent()
+			go func() {
+				m.HandleEvent()
+				close(done)
+			}()
+			var wakeFds []int
+			switch v := m.(type) {
+			case *Epoll:
+				wakeFds = []int{v.wakeFd}
+			case *Poll:
+				wakeFds = v.wakeFds[:]
+			}
+			// 等WaitEvent阻塞
+			time.Sleep(50 * time.Millisecond)
+			m.Stop()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Stop后HandleEvent未返回")
+			}
+			// 唤醒用的fd在WaitEvent返回前关闭
+			for _, fd := range wakeFds {
+				if _, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != unix.EBADF {
+					t.Errorf("fd %d未关闭", fd)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package go_conn_manager
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenConfig 监听套接字的选项
+type ListenConfig struct {
+	Backlog     int           // listen的backlog，为0时使用Listen_Queue_Size
+	ReuseAddr   bool          // SO_REUSEADDR，重启服务时不必等待TIME_WAIT状态的连接超时
+	ReusePort   bool          // SO_REUSEPORT，多个监听套接字绑定同一端口，由内核分配新连接
+	DeferAccept time.Duration // TCP_DEFER_ACCEPT，连接上有数据到达后才接受，精确到秒，为0时不设置
+	FastOpen    int           // TCP_FASTOPEN的队列长度，为0时不开启
+}
+
+// DefaultListenConfig 返回默认的监听套接字选项：backlog为Listen_Queue_Size，开启SO_REUSEADDR
+func DefaultListenConfig() ListenConfig {
+	return ListenConfig{
+		Backlog:   Listen_Queue_Size,
+		ReuseAddr: true,
+	}
+}
+
+// SocketOptions 新连接的套接字选项，接受连接后、调用OnConnect前设置
+type SocketOptions struct {
+	NoDelay     bool          // TCP_NODELAY，关闭Nagle算法，小数据包不等待合并直接发送
+	RecvBuffer  int           // SO_RCVBUF，为0时使用系统默认值
+	SendBuffer  int           // SO_SNDBUF，为0时使用系统默认值
+	UserTimeout time.Duration // TCP_USER_TIMEOUT，发送的数据超过该时间未被确认时内核关闭连接，为0时不设置
+	// SO_LINGER的秒数，为0时不设置；小于0时关闭套接字会丢弃未发送的数据并发送RST
+	Linger int
+	// Control 设置其他选项，或者根据连接（如对方的地址）设置不同的选项，返回错误时关闭该连接
+	Control func(c *Conn) error
+}
+
+// DefaultSocketOptions 返回默认的新连接的套接字选项：开启TCP_NODELAY，与标准库net相同
+func DefaultSocketOptions() SocketOptions {
+	return SocketOptions{NoDelay: true}
+}
+
+// SetListenConfig 设置监听套接字的选项，需要在Init之前调用，默认为DefaultListenConfig
+func (en *engine) SetListenConfig(cfg ListenConfig) {
+	en.listenConfig = &cfg
+}
+
+// SetSocketOptions 设置新连接的套接字选项，默认为DefaultSocketOptions
+func (en *engine) SetSocketOptions(opts SocketOptions) {
+	en.socketOptions = &opts
+}
+
+// listen 按ListenConfig创建非阻塞的监听套接字，ipAddr为IPv4或IPv6地址，为空时监听所有IPv4地址
+func (en *engine) listen(ipAddr string, port int) (int, error) {
+	cfg := DefaultListenConfig()
+	if en.listenConfig != nil {
+		cfg = *en.listenConfig
+	}
+	if cfg.Backlog <= 0 {
+		cfg.Backlog = Listen_Queue_Size
+	}
+
+	sa, family, err := listenSockaddr(ipAddr, port)
+	if err != nil {
+		return -1, err
+	}
+	// 监听套接字为非阻塞的，边缘触发时需要循环接受连接直到EAGAIN，见acceptAll
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	err = setListenOptions(fd, cfg)
+	if err == nil {
+		err = syscall.Bind(fd, sa)
+	}
+	if err == nil {
+		err = syscall.Listen(fd, cfg.Backlog)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+func setListenOptions(fd int, cfg ListenConfig) error {
+	if cfg.ReuseAddr {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			return err
+		}
+	}
+	if cfg.ReusePort {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return err
+		}
+	}
+	if cfg.DeferAccept > 0 {
+		secs := int((cfg.DeferAccept + time.Second - 1) / time.Second)
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, secs); err != nil {
+			return err
+		}
+	}
+	if cfg.FastOpen > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN, cfg.FastOpen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setSocketOptions 按SocketOptions设置新连接的套接字选项
+func (en *engine) setSocketOptions(c *Conn) error {
+	opts := DefaultSocketOptions()
+	if en.socketOptions != nil {
+		opts = *en.socketOptions
+	}
+
+	fd := c.fd
+	if opts.NoDelay {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, 1); err != nil {
+			return err
+		}
+	}
+	if opts.RecvBuffer > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, opts.RecvBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.SendBuffer > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, opts.SendBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.UserTimeout > 0 {
+		ms := int(opts.UserTimeout / time.Millisecond)
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, ms); err != nil {
+			return err
+		}
+	}
+	if opts.Linger != 0 {
+		l := &unix.Linger{Onoff: 1}
+		if opts.Linger > 0 {
+			l.Linger = int32(opts.Linger)
+		}
+		if err := unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, l); err != nil {
+			return err
+		}
+	}
+	if opts.Control != nil {
+		return opts.Control(c)
+	}
+	return nil
+}
+
+// listenSockaddr 把监听的地址与端口转换为syscall.Sockaddr，并返回对应的地址族
+func listenSockaddr(ipAddr string, port int) (syscall.Sockaddr, int, error) {
+	if ipAddr == "" {
+		return &syscall.SockaddrInet4{Port: port}, syscall.AF_INET, nil
+	}
+	ip, err := netip.ParseAddr(ipAddr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if ip.Is4() || ip.Is4In6() {
+		return &syscall.SockaddrInet4{Port: port, Addr: ip.Unmap().As4()}, syscall.AF_INET, nil
+	}
+	sa := &syscall.SockaddrInet6{Port: port, Addr: ip.As16()}
+	if zone := ip.Zone(); zone != "" {
+		ifi, err := net.InterfaceByName(zone)
+		if err != nil {
+			return nil, 0, err
+		}
+		sa.ZoneId = uint32(ifi.Index)
+	}
+	return sa, syscall.AF_INET6, nil
+}
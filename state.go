@@ -0,0 +1,102 @@
+package go_conn_manager
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ConnState 连接的状态
+type ConnState int32
+
+const (
+	Conn_State_Accepted    ConnState = iota // 已接受连接，OnConnect返回前的状态
+	Conn_State_Handshaking                  // 握手中（如TLS握手、登录认证），由BeginHandshake进入，Activate结束
+	Conn_State_Active                       // 正常通信，OnConnect返回后未进入握手状态时自动进入该状态
+	Conn_State_Draining                     // 正在关闭：不再接受新的发送，等待发送队列中的数据发送完
+	Conn_State_Closed                       // 已关闭
+)
+
+var connStateNames = [...]string{
+	Conn_State_Accepted:    "Accepted",
+	Conn_State_Handshaking: "Handshaking",
+	Conn_State_Active:      "Active",
+	Conn_State_Draining:    "Draining",
+	Conn_State_Closed:      "Closed",
+}
+
+func (s ConnState) String() string {
+	if s >= 0 && int(s) < len(connStateNames) {
+		return connStateNames[s]
+	}
+	return fmt.Sprintf("ConnState(%d)", int32(s))
+}
+
+var (
+	// ErrInvalidState 连接当前的状态不能转换为目标状态
+	ErrInvalidState = errors.New("连接状态不能转换")
+	// ErrConnDraining 连接正在关闭时发送数据返回该错误，errors.Is(err, ErrWriteClosed)也成立
+	ErrConnDraining = fmt.Errorf("连接正在关闭: %w", ErrWriteClosed)
+)
+
+// canTransition 返回状态from能否转换为to，状态只能向后转换，已关闭的连接不能再转换
+func canTransition(from, to ConnState) bool {
+	return from < Conn_State_Closed && to > from && to <= Conn_State_Closed
+}
+
+// State 返回连接当前的状态
+func (c *Conn) State() ConnState {
+	return ConnState(atomic.LoadInt32(&c.state))
+}
+
+// BeginHandshake 进入握手状态，只能在Accepted状态（一般在OnConnect中）调用，
+// 此时OnConnect返回后不会自动进入Active状态，握手完成后需调用Activate
+func (c *Conn) BeginHandshake() error {
+	if !c.compareAndSwapState(Conn_State_Accepted, Conn_State_Handshaking) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidState, c.State(), Conn_State_Handshaking)
+	}
+	return nil
+}
+
+// Activate 握手完成，进入Active状态
+func (c *Conn) Activate() error {
+	if !c.setState(Conn_State_Active) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidState, c.State(), Conn_State_Active)
+	}
+	return nil
+}
+
+// setState 把状态转换为to，当前状态不能转换为to时返回false
+func (c *Conn) setState(to ConnState) bool {
+	for {
+		from := c.State()
+		if !canTransition(from, to) {
+			return false
+		}
+		if c.compareAndSwapState(from, to) {
+			return true
+		}
+	}
+}
+
+// compareAndSwapState 当前状态为from时转换为to，并调用StateChangeHandler.OnStateChange
+func (c *Conn) compareAndSwapState(from, to ConnState) bool {
+	if !canTransition(from, to) || !atomic.CompareAndSwapInt32(&c.state, int32(from), int32(to)) {
+		return false
+	}
+	if h, ok := c.en.handler.(StateChangeHandler); ok {
+		h.OnStateChange(c, from, to)
+	}
+	return true
+}
+
+// stateError 返回当前状态下不能发送数据的错误，可以发送时返回nil
+func (c *Conn) stateError() error {
+	switch c.State() {
+	case Conn_State_Draining:
+		return ErrConnDraining
+	case Conn_State_Closed:
+		return ErrConnClosed
+	}
+	return nil
+}
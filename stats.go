@@ -0,0 +1,103 @@
+package go_conn_manager
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TrafficStats 流量统计
+type TrafficStats struct {
+	BytesIn         uint64 // 从套接字读取的字节数
+	BytesOut        uint64 // 写入套接字的字节数
+	FramesIn        uint64 // 交给OnMessage处理的数据包数
+	FramesOut       uint64 // 成功发送（写入套接字或放入发送队列）的数据包数
+	DroppedFrames   uint64 // 被丢弃的数据包数
+	OversizedFrames uint64 // 接收到的超出最大长度的数据包数
+}
+
+func (s *TrafficStats) add(o TrafficStats) {
+	s.BytesIn += o.BytesIn
+	s.BytesOut += o.BytesOut
+	s.FramesIn += o.FramesIn
+	s.FramesOut += o.FramesOut
+	s.DroppedFrames += o.DroppedFrames
+	s.OversizedFrames += o.OversizedFrames
+}
+
+// ConnStats 连接的统计快照，由Conn.Stats获取
+type ConnStats struct {
+	TrafficStats
+	ConnectTime   time.Time // 接受连接的时间
+	LastReadTime  time.Time // 最后一次从套接字读到数据的时间
+	LastWriteTime time.Time // 最后一次向套接字写入数据的时间，未写过时为零值
+}
+
+// connStats 连接的统计计数，可以在任意goroutine中并发更新
+type connStats struct {
+	bytesIn         atomic.Uint64
+	bytesOut        atomic.Uint64
+	framesIn        atomic.Uint64
+	framesOut       atomic.Uint64
+	droppedFrames   atomic.Uint64
+	oversizedFrames atomic.Uint64
+
+	connectTime int64        // UnixNano，创建后不再修改
+	lastRead    atomic.Int64 // UnixNano
+	lastWrite   atomic.Int64 // UnixNano
+}
+
+func (s *connStats) traffic() TrafficStats {
+	return TrafficStats{
+		BytesIn:         s.bytesIn.Load(),
+		BytesOut:        s.bytesOut.Load(),
+		FramesIn:        s.framesIn.Load(),
+		FramesOut:       s.framesOut.Load(),
+		DroppedFrames:   s.droppedFrames.Load(),
+		OversizedFrames: s.oversizedFrames.Load(),
+	}
+}
+
+// read 记录从套接字读到n字节
+func (s *connStats) read(n int) {
+	s.bytesIn.Add(uint64(n))
+	s.lastRead.Store(time.Now().UnixNano())
+}
+
+// written 记录向套接字写入n字节
+func (s *connStats) written(n int) {
+	if n <= 0 {
+		return
+	}
+	s.bytesOut.Add(uint64(n))
+	s.lastWrite.Store(time.Now().UnixNano())
+}
+
+// Stats 返回该连接的统计快照
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		TrafficStats:  c.stats.traffic(),
+		ConnectTime:   time.Unix(0, c.stats.connectTime),
+		LastReadTime:  time.Unix(0, c.stats.lastRead.Load()),
+		LastWriteTime: unixNanoTime(c.stats.lastWrite.Load()),
+	}
+}
+
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// Stats 返回该管理器中所有连接（包括已关闭的连接）的流量合计
+func (cm *ConnManager) Stats() TrafficStats {
+	cm.statsMu.Lock()
+	total := cm.closedStats
+	cm.statsMu.Unlock()
+
+	cm.Range(func(c *Conn) bool {
+		total.add(c.stats.traffic())
+		return true
+	})
+	return total
+}
@@ -0,0 +1,167 @@
+package go_conn_manager
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	Timing_Wheel_Tick = 100 * time.Millisecond // 时间轮的精度，空闲超时最多比设置的时间晚一个tick
+
+	timingWheelBits   = 6
+	timingWheelSlots  = 1 << timingWheelBits
+	timingWheelMask   = timingWheelSlots - 1
+	timingWheelLevels = 4 // 可以表示的最长时间为64^4个tick，100ms时约为19天，更长的时间按最长时间加入，到期后再重新加入
+)
+
+// wheelEntry 时间轮中的一项，嵌入在Conn中，加入与删除不需要分配内存，由timingWheel.mu保护
+type wheelEntry struct {
+	prev, next *wheelEntry
+	slot       *wheelSlot // 所在的槽，为nil时不在时间轮中
+	expire     int64      // 到期的tick
+	registered bool       // 连接已加入管理器，之后才加入时间轮
+	dead       bool       // 连接已从管理器中删除，不再加入时间轮
+	conn       *Conn
+}
+
+// wheelSlot 时间轮的槽，保存同一时间到期（或需要降级）的项
+type wheelSlot struct {
+	head *wheelEntry
+}
+
+func (s *wheelSlot) push(e *wheelEntry) {
+	e.prev = nil
+	e.next = s.head
+	if s.head != nil {
+		s.head.prev = e
+	}
+	s.head = e
+	e.slot = s
+}
+
+func (s *wheelSlot) unlink(e *wheelEntry) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		s.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	e.prev, e.next, e.slot = nil, nil, nil
+}
+
+// timingWheel 分层时间轮：第l层的每个槽对应64^l个tick，项按距离到期的时间放入对应的层，
+// 高层的槽到期时把其中的项重新放入低层，第0层的槽到期时其中的项到期。
+// 加入与删除都是O(1)，每个tick只处理到期的槽
+type timingWheel struct {
+	mu    sync.Mutex
+	tick  time.Duration
+	start int64 // 第0个tick的时间（UnixNano）
+	now   int64 // 已处理到的tick
+	slots [timingWheelLevels][timingWheelSlots]wheelSlot
+}
+
+func (tw *timingWheel) init(tick time.Duration) {
+	tw.tick = tick
+	tw.start = time.Now().UnixNano()
+}
+
+// add 把e加入时间轮，在at（UnixNano）时到期，已在时间轮中时先删除
+func (tw *timingWheel) add(e *wheelEntry, at int64) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if !e.registered || e.dead {
+		return
+	}
+	// 向上取整，不会提前到期
+	tw.addLocked(e, (at-tw.start+int64(tw.tick)-1)/int64(tw.tick))
+}
+
+func (tw *timingWheel) addLocked(e *wheelEntry, expire int64) {
+	if e.slot != nil {
+		e.slot.unlink(e)
+	}
+	if expire <= tw.now {
+		expire = tw.now + 1
+	}
+	delta := expire - tw.now
+	if max := int64(1)<<(timingWheelBits*timingWheelLevels) - 1; delta > max {
+		delta = max
+		expire = tw.now + max
+	}
+
+	level := 0
+	for delta >= int64(1)<<(timingWheelBits*(level+1)) {
+		level++
+	}
+	e.expire = expire
+	tw.slots[level][(expire>>(timingWheelBits*level))&timingWheelMask].push(e)
+}
+
+// remove 从时间轮中删除e
+func (tw *timingWheel) remove(e *wheelEntry) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if e.slot != nil {
+		e.slot.unlink(e)
+	}
+}
+
+// register 连接已加入管理器，之后才能加入时间轮
+func (tw *timingWheel) register(e *wheelEntry) {
+	tw.mu.Lock()
+	e.registered = true
+	tw.mu.Unlock()
+}
+
+// unregister 连接已从管理器中删除，从时间轮中删除并不再加入
+func (tw *timingWheel) unregister(e *wheelEntry) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	e.dead = true
+	if e.slot != nil {
+		e.slot.unlink(e)
+	}
+}
+
+// advance 推进到now（UnixNano）对应的tick，返回这期间到期的连接，返回的项已不在时间轮中
+func (tw *timingWheel) advance(now int64) []*Conn {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	var expired []*Conn
+	target := (now - tw.start) / int64(tw.tick)
+	for tw.now < target {
+		tw.now++
+		// 低层转完一圈时把高层当前槽中的项降级
+		for level := 1; level < timingWheelLevels; level++ {
+			if tw.now&(int64(1)<<(timingWheelBits*level)-1) != 0 {
+				break
+			}
+			tw.cascade(&tw.slots[level][(tw.now>>(timingWheelBits*level))&timingWheelMask])
+		}
+
+		slot := &tw.slots[0][tw.now&timingWheelMask]
+		for e := slot.head; e != nil; e = slot.head {
+			slot.unlink(e)
+			expired = append(expired, e.conn)
+		}
+	}
+	return expired
+}
+
+func (tw *timingWheel) cascade(slot *wheelSlot) {
+	for e := slot.head; e != nil; e = slot.head {
+		slot.unlink(e)
+		if e.expire == tw.now {
+			// 降级后在接下来处理的第0层的槽中到期
+			tw.slots[0][tw.now&timingWheelMask].push(e)
+			continue
+		}
+		tw.addLocked(e, e.expire)
+	}
+}
@@ -0,0 +1,113 @@
+package go_conn_manager
+
+import (
+	"testing"
+)
+
+// newTestWheel 返回tick为1ns、从第now个tick开始的时间轮，add与advance的时间即为tick数
+func newTestWheel(now int64) *timingWheel {
+	return &timingWheel{tick: 1, now: now}
+}
+
+func newTestEntry() *wheelEntry {
+	c := &Conn{}
+	c.idle.conn = c
+	c.idle.registered = true
+	return &c.idle
+}
+
+func TestTimingWheel(t *testing.T) {
+	const span = int64(1) << (timingWheelBits * timingWheelLevels) // 可以表示的时间范围
+	tests := []struct {
+		name   string
+		now    int64 // 时间轮开始的tick
+		at     int64 // 加入时设置的到期tick
+		expire int64 // 实际到期的tick
+	}{
+		{"下一个tick", 0, 1, 1},
+		{"已过期", 100, 50, 101},
+		{"当前tick", 100, 100, 101},
+		{"第0层最后一个槽", 0, 63, 63},
+		{"第1层", 0, 64, 64},
+		{"第1层不对齐", 0, 65, 65},
+		{"跨第0层一圈", 60, 70, 70},
+		{"从不对齐的位置进入第1层", 37, 37 + 64, 37 + 64},
+		{"第1层最后一个槽", 0, 4095, 4095},
+		{"第2层", 0, 4096, 4096},
+		{"第2层不对齐", 1000, 1000 + 4097, 1000 + 4097},
+		{"两次降级", 4000, 4000 + 64*64*3 + 65, 4000 + 64*64*3 + 65},
+		{"第3层", 0, 64 * 64 * 64, 64 * 64 * 64},
+		{"第3层不对齐", 123, 123 + 64*64*64*5 + 64*7 + 3, 123 + 64*64*64*5 + 64*7 + 3},
+		{"最长时间", 0, span - 1, span - 1},
+		{"超出最长时间", 10, 10 + span + 100, 10 + span - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tw := newTestWheel(tt.now)
+			e := newTestEntry()
+			tw.add(e, tt.at)
+			if e.expire != tt.expire {
+				t.Fatalf("到期tick为%d，应为%d", e.expire, tt.expire)
+			}
+			if expired := tw.advance(tt.expire - 1); len(expired) != 0 {
+				t.Fatalf("第%d个tick提前到期", tw.now)
+			}
+			expired := tw.advance(tt.expire)
+			if len(expired) != 1 || expired[0] != e.conn {
+				t.Fatalf("第%d个tick未到期: %v", tw.now, expired)
+			}
+			if e.slot != nil {
+				t.Fatal("到期后仍在时间轮中")
+			}
+		})
+	}
+}
+
+func TestTimingWheelUpdate(t *testing.T) {
+	tw := newTestWheel(0)
+	a, b, c := newTestEntry(), newTestEntry(), newTestEntry()
+	tw.add(a, 10)
+	tw.add(b, 10)
+	tw.add(c, 5000)
+
+	// 重新加入时从原来的槽中删除
+	tw.add(b, 100)
+	tw.remove(c)
+	if expired := tw.advance(10); len(expired) != 1 || expired[0] != a.conn {
+		t.Fatalf("第10个tick到期的连接: %v", expired)
+	}
+	if expired := tw.advance(100); len(expired) != 1 || expired[0] != b.conn {
+		t.Fatalf("第100个tick到期的连接: %v", expired)
+	}
+	if expired := tw.advance(10000); len(expired) != 0 {
+		t.Fatalf("删除后仍然到期: %v", expired)
+	}
+
+	// 未加入管理器或者已删除时不加入
+	d := newTestEntry()
+	d.registered = false
+	tw.add(d, 10001)
+	tw.register(a)
+	tw.add(a, 10001)
+	tw.unregister(a)
+	tw.add(a, 10001)
+	if d.slot != nil || a.slot != nil {
+		t.Fatal("未注册或者已注销的项加入了时间轮")
+	}
+}
+
+// add的时间向上取整到tick，不会提前到期
+func TestTimingWheelRoundUp(t *testing.T) {
+	tw := &timingWheel{tick: 100}
+	e := newTestEntry()
+	tw.add(e, 101)
+	if e.expire != 2 {
+		t.Fatalf("到期tick为%d，应为2", e.expire)
+	}
+	if expired := tw.advance(199); len(expired) != 0 {
+		t.Fatal("提前到期")
+	}
+	if expired := tw.advance(200); len(expired) != 1 {
+		t.Fatal("未到期")
+	}
+}